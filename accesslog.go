@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/config"
+	"github.com/Ling-Qingran/gRPC-Observability/redact"
+	"github.com/Ling-Qingran/gRPC-Observability/requestid"
+	"github.com/Ling-Qingran/gRPC-Observability/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// accessLogConfig controls which calls are logged and what they contain.
+type accessLogConfig struct {
+	// SampleRate is the fraction of successful calls that are logged.
+	// Errors and slow calls are always logged.
+	SampleRate float64
+	// SlowThreshold marks calls that took at least this long as slow. Zero
+	// marks none.
+	SlowThreshold time.Duration
+	// RequestBody logs the request message.
+	RequestBody bool
+	// RedactFields names request fields whose values are never logged.
+	RedactFields redact.Fields
+}
+
+var (
+	// accessLevel is the minimum level logged. It and accessLog are swapped
+	// on configuration reload, so calls read them once per call.
+	accessLevel  = new(slog.LevelVar)
+	accessLogger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: accessLevel}))
+	accessLog    atomic.Pointer[accessLogConfig]
+)
+
+func init() {
+	accessLog.Store(newAccessLogConfig(config.Default().AccessLog))
+}
+
+func newAccessLogConfig(c config.AccessLog) *accessLogConfig {
+	return &accessLogConfig{
+		SampleRate:    c.SampleRate,
+		SlowThreshold: c.SlowThreshold,
+		RequestBody:   c.RequestBody,
+		RedactFields:  redact.NewFields(c.RedactFields),
+	}
+}
+
+// AccessLogInterceptor writes one JSON log line per call. Successful calls
+// are sampled; errors and calls slower than the threshold are always logged.
+func AccessLogInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	duration := time.Since(start)
+
+	cfg := accessLog.Load()
+	slow := cfg.SlowThreshold > 0 && duration >= cfg.SlowThreshold
+	if err == nil && !slow && rand.Float64() >= cfg.SampleRate {
+		return resp, err
+	}
+
+	code := status.Code(err)
+	attrs := []slog.Attr{
+		slog.String("method", info.FullMethod),
+		slog.String("code", code.String()),
+		slog.Duration("duration", duration),
+		slog.Int("request_size", messageSize(req)),
+		slog.Int("response_size", messageSize(resp)),
+		slog.String("peer", clientAddress(ctx)),
+		slog.String("user_agent", firstMetadata(ctx, "user-agent")),
+		slog.String("request_id", requestid.FromContext(ctx)),
+		slog.Bool("slow", slow),
+	}
+	if span := tracing.FromContext(ctx); span != nil {
+		attrs = append(attrs, slog.String("trace_id", span.TraceIDString()))
+	}
+	if cfg.RequestBody {
+		if body := redact.Message(req, cfg.RedactFields); body != nil {
+			attrs = append(attrs, slog.Any("request", body))
+		}
+	}
+
+	level := slog.LevelInfo
+	switch {
+	case err != nil:
+		level = slog.LevelError
+		attrs = append(attrs, slog.String("error", status.Convert(err).Message()))
+	case slow:
+		level = slog.LevelWarn
+	}
+	accessLogger.LogAttrs(ctx, level, "rpc", attrs...)
+	return resp, err
+}
+
+func firstMetadata(ctx context.Context, key string) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+func messageSize(m interface{}) int {
+	if msg, ok := m.(proto.Message); ok && msg != nil {
+		return proto.Size(msg)
+	}
+	return 0
+}
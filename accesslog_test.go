@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/redact"
+	"github.com/Ling-Qingran/gRPC-Observability/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// captureAccessLog installs cfg and returns the buffer access log lines are
+// written to.
+func captureAccessLog(t *testing.T, cfg accessLogConfig) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prevLogger, prevConfig := accessLogger, accessLog.Load()
+	accessLogger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: accessLevel}))
+	accessLog.Store(&cfg)
+	t.Cleanup(func() {
+		accessLogger = prevLogger
+		accessLog.Store(prevConfig)
+	})
+	return &buf
+}
+
+// accessLogLines parses the lines written to buf.
+func accessLogLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("access log line %q: %v", line, err)
+		}
+		lines = append(lines, m)
+	}
+	return lines
+}
+
+// callAccessLog runs AccessLogInterceptor around a handler that takes delay
+// and returns err.
+func callAccessLog(req interface{}, delay time.Duration, err error) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/UserService/GetUser"}
+	AccessLogInterceptor(context.Background(), req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		time.Sleep(delay)
+		if err != nil {
+			return nil, err
+		}
+		return &user.User{Name: "John Doe"}, nil
+	})
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	req := &user.GetUserRequest{Name: "John Doe"}
+	for _, tc := range []struct {
+		name      string
+		cfg       accessLogConfig
+		delay     time.Duration
+		err       error
+		wantLevel string
+	}{
+		{"unsampled success", accessLogConfig{SampleRate: 0, SlowThreshold: time.Hour}, 0, nil, ""},
+		{"sampled success", accessLogConfig{SampleRate: 1, SlowThreshold: time.Hour}, 0, nil, "INFO"},
+		{"unsampled error", accessLogConfig{SampleRate: 0, SlowThreshold: time.Hour}, 0, status.Error(codes.NotFound, "user not found"), "ERROR"},
+		{"unsampled slow call", accessLogConfig{SampleRate: 0, SlowThreshold: time.Millisecond}, 5 * time.Millisecond, nil, "WARN"},
+		{"no slow threshold", accessLogConfig{SampleRate: 0}, 5 * time.Millisecond, nil, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := captureAccessLog(t, tc.cfg)
+			callAccessLog(req, tc.delay, tc.err)
+			lines := accessLogLines(t, buf)
+			if tc.wantLevel == "" {
+				if len(lines) != 0 {
+					t.Fatalf("logged %v, want nothing", lines)
+				}
+				return
+			}
+			if len(lines) != 1 {
+				t.Fatalf("logged %d lines, want 1", len(lines))
+			}
+			line := lines[0]
+			if line["level"] != tc.wantLevel {
+				t.Errorf("level = %v, want %s", line["level"], tc.wantLevel)
+			}
+			if line["method"] != "/UserService/GetUser" {
+				t.Errorf("method = %v, want /UserService/GetUser", line["method"])
+			}
+			if wantSlow := tc.wantLevel == "WARN"; line["slow"] != wantSlow {
+				t.Errorf("slow = %v, want %v", line["slow"], wantSlow)
+			}
+			if tc.err != nil && (line["code"] != "NotFound" || line["error"] != "user not found") {
+				t.Errorf("code, error = %v, %v, want NotFound, user not found", line["code"], line["error"])
+			}
+			if _, ok := line["request"]; ok {
+				t.Error("request body logged without RequestBody")
+			}
+		})
+	}
+}
+
+func TestAccessLogRequestBody(t *testing.T) {
+	buf := captureAccessLog(t, accessLogConfig{SampleRate: 1, RequestBody: true, RedactFields: redact.NewFields([]string{"name", "commute_method"})})
+	callAccessLog(&user.UpdateUserRequest{Name: "John Doe", User: &user.User{Name: "John Doe", Age: "25", CommuteMethod: "Car"}}, 0, nil)
+
+	lines := accessLogLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("logged %d lines, want 1", len(lines))
+	}
+	want := map[string]interface{}{
+		"name": redact.Value,
+		"user": map[string]interface{}{"name": redact.Value, "age": "25", "commuteMethod": redact.Value},
+	}
+	got, _ := json.Marshal(lines[0]["request"])
+	wantJSON, _ := json.Marshal(want)
+	if string(got) != string(wantJSON) {
+		t.Errorf("request = %s, want %s", got, wantJSON)
+	}
+}
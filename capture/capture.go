@@ -0,0 +1,91 @@
+// Package capture records sampled request/response pairs to a JSONL file so
+// that calls seen in production can be replayed against another server.
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/redact"
+	"github.com/Ling-Qingran/gRPC-Observability/requestid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Record is one captured call, stored as a single line of JSON.
+type Record struct {
+	Time       time.Time   `json:"time"`
+	Method     string      `json:"method"`
+	RequestID  string      `json:"request_id,omitempty"`
+	DurationMS float64     `json:"duration_ms"`
+	Code       string      `json:"code"`
+	Error      string      `json:"error,omitempty"`
+	Request    interface{} `json:"request"`
+	Response   interface{} `json:"response,omitempty"`
+}
+
+// Recorder appends captured calls to a file.
+type Recorder struct {
+	// SampleRate is the fraction of calls that are recorded.
+	SampleRate float64
+	// RedactFields are masked in both requests and responses.
+	RedactFields redact.Fields
+
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// NewRecorder opens path for appending, creating it if needed.
+func NewRecorder(path string, sampleRate float64, redactFields redact.Fields) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{
+		SampleRate:   sampleRate,
+		RedactFields: redactFields,
+		f:            f,
+		enc:          json.NewEncoder(f),
+	}, nil
+}
+
+// UnaryServerInterceptor records a sample of the calls it sees. Failures to
+// write are dropped: capture must never fail the call being captured.
+func (r *Recorder) UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	if rand.Float64() >= r.SampleRate {
+		return resp, err
+	}
+
+	rec := Record{
+		Time:       start,
+		Method:     info.FullMethod,
+		RequestID:  requestid.FromContext(ctx),
+		DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+		Code:       status.Code(err).String(),
+		Request:    redact.Message(req, r.RedactFields),
+	}
+	if err != nil {
+		rec.Error = status.Convert(err).Message()
+	} else {
+		rec.Response = redact.Message(resp, r.RedactFields)
+	}
+
+	r.mu.Lock()
+	r.enc.Encode(rec)
+	r.mu.Unlock()
+	return resp, err
+}
+
+// Close closes the capture file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
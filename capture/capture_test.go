@@ -0,0 +1,96 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Ling-Qingran/gRPC-Observability/redact"
+	"github.com/Ling-Qingran/gRPC-Observability/requestid"
+	"github.com/Ling-Qingran/gRPC-Observability/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// readRecords returns the records in the capture file at path.
+func readRecords(t *testing.T, path string) []Record {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var recs []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("record %q: %v", scanner.Text(), err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "captures.jsonl")
+	r, err := NewRecorder(path, 1, redact.NewFields([]string{"age"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/UserService/GetUser"}
+	ctx := requestid.NewContext(context.Background(), "req-1")
+
+	found := func(context.Context, interface{}) (interface{}, error) {
+		return &user.User{Name: "John Doe", Age: "25"}, nil
+	}
+	notFound := func(context.Context, interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	r.UnaryServerInterceptor(ctx, &user.GetUserRequest{Name: "John Doe"}, info, found)
+	if _, err := r.UnaryServerInterceptor(ctx, &user.GetUserRequest{Name: "Nobody"}, info, notFound); status.Code(err) != codes.NotFound {
+		t.Errorf("error = %v, want the handler's NotFound", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recs := readRecords(t, path)
+	if len(recs) != 2 {
+		t.Fatalf("recorded %d calls, want 2", len(recs))
+	}
+	ok, failed := recs[0], recs[1]
+	if ok.Method != "/UserService/GetUser" || ok.RequestID != "req-1" || ok.Code != "OK" || ok.Error != "" {
+		t.Errorf("record = %+v, want an OK GetUser with request ID req-1", ok)
+	}
+	resp, _ := ok.Response.(map[string]interface{})
+	if resp["name"] != "John Doe" || resp["age"] != redact.Value {
+		t.Errorf("response = %v, want name kept and age redacted", ok.Response)
+	}
+	if failed.Code != "NotFound" || failed.Error != "user not found" || failed.Response != nil {
+		t.Errorf("record = %+v, want NotFound with its message and no response", failed)
+	}
+	if req, _ := failed.Request.(map[string]interface{}); req["name"] != "Nobody" {
+		t.Errorf("request = %v, want the name", failed.Request)
+	}
+}
+
+func TestRecorderSampleRate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "captures.jsonl")
+	r, err := NewRecorder(path, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/UserService/GetUser"}
+	r.UnaryServerInterceptor(context.Background(), &user.GetUserRequest{}, info, func(context.Context, interface{}) (interface{}, error) {
+		return &user.User{}, nil
+	})
+	r.Close()
+	if recs := readRecords(t, path); len(recs) != 0 {
+		t.Errorf("recorded %d calls at sample rate 0, want none", len(recs))
+	}
+}
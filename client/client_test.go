@@ -0,0 +1,225 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/influxtest"
+	"github.com/Ling-Qingran/gRPC-Observability/metrics"
+	"github.com/Ling-Qingran/gRPC-Observability/registry"
+	"github.com/Ling-Qingran/gRPC-Observability/status"
+	"github.com/Ling-Qingran/gRPC-Observability/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+type stubStatusService struct {
+	status.UnimplementedStatusServiceServer
+}
+
+func (stubStatusService) CheckStatus(context.Context, *status.StatusRequest) (*status.StatusResponse, error) {
+	return &status.StatusResponse{Version: "test"}, nil
+}
+
+type stubUserService struct {
+	user.UnimplementedUserServiceServer
+}
+
+func (stubUserService) GetUser(context.Context, *user.GetUserRequest) (*user.User, error) {
+	return &user.User{Name: "John Doe"}, nil
+}
+
+// startServer serves the stub services and the health service over an
+// in-memory connection, with the server as a whole NOT_SERVING as it is while
+// storage is down, and returns the dial options reaching it.
+func startServer(t *testing.T) []grpc.DialOption {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	status.RegisterStatusServiceServer(s, stubStatusService{})
+	user.RegisterUserServiceServer(s, stubUserService{})
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthServer.SetServingStatus(user.UserService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+	healthServer.SetServingStatus(status.StatusService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, healthServer)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	return []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+	}
+}
+
+// registryTarget resolves registry:/// targets to the in-memory server.
+func registryTarget() grpc.DialOption {
+	r := manual.NewBuilderWithScheme(registry.Scheme)
+	r.InitialState(resolver.State{Addresses: []resolver.Address{{Addr: "bufnet"}}})
+	return grpc.WithResolvers(r)
+}
+
+func TestStatusReachableWhileServerNotServing(t *testing.T) {
+	opts := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, target := range []string{"bufnet", registry.Scheme + ":///UserService"} {
+		statusClient, conn, err := DialStatusService(target, append(opts, registryTarget())...)
+		if err != nil {
+			t.Fatalf("dial %s: %v", target, err)
+		}
+		defer conn.Close()
+		if _, err := statusClient.CheckStatus(ctx, &status.StatusRequest{}); err != nil {
+			t.Errorf("CheckStatus via %s: %v", target, err)
+		}
+	}
+
+	// Without a registry target there is no health checking
+	users, conn, err := DialUserService("bufnet", opts...)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := users.GetUser(ctx, &user.GetUserRequest{Name: "John Doe"}); err != nil {
+		t.Errorf("GetUser: %v", err)
+	}
+}
+
+func TestRegistryTargetSkipsUnhealthyService(t *testing.T) {
+	opts := startServer(t)
+	users, conn, err := DialUserService(registry.Scheme+":///UserService", append(opts, registryTarget())...)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := users.GetUser(ctx, &user.GetUserRequest{Name: "John Doe"}); grpcstatus.Code(err) != codes.Unavailable && grpcstatus.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("GetUser with UserService NOT_SERVING: error = %v, want Unavailable", err)
+	}
+}
+
+// flakyUserService fails the first failures GetUser calls and every
+// CreateUser call with UNAVAILABLE, counting the calls it receives.
+type flakyUserService struct {
+	user.UnimplementedUserServiceServer
+	failures    int32
+	getCalls    atomic.Int32
+	createCalls atomic.Int32
+}
+
+func (s *flakyUserService) GetUser(context.Context, *user.GetUserRequest) (*user.User, error) {
+	if s.getCalls.Add(1) <= s.failures {
+		return nil, grpcstatus.Error(codes.Unavailable, "try again")
+	}
+	return &user.User{Name: "John Doe"}, nil
+}
+
+func (s *flakyUserService) CreateUser(context.Context, *user.CreateUserRequest) (*user.User, error) {
+	s.createCalls.Add(1)
+	return nil, grpcstatus.Error(codes.Unavailable, "try again")
+}
+
+// startFlakyServer serves svc over an in-memory connection, points the
+// metrics sink at a fake InfluxDB and returns a client dialed with Dial.
+func startFlakyServer(t *testing.T, svc *flakyUserService) (user.UserServiceClient, *influxtest.Server) {
+	t.Helper()
+	influx := influxtest.NewServer("test-org", "test-bucket", "test-token")
+	t.Cleanup(influx.Close)
+	metrics.SetSink(metrics.Sink{URL: influx.URL, Token: influx.Token, Org: influx.Org, Bucket: influx.Bucket})
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	user.RegisterUserServiceServer(s, svc)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	users, conn, err := DialUserService("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return users, influx
+}
+
+func TestClientMetricsCountRetries(t *testing.T) {
+	svc := &flakyUserService{failures: 2}
+	users, influx := startFlakyServer(t, svc)
+
+	req := &user.GetUserRequest{Name: "John Doe"}
+	resp, err := users.GetUser(context.Background(), req)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if got := svc.getCalls.Load(); got != 3 {
+		t.Errorf("server saw %d GetUser calls, want 3", got)
+	}
+
+	points := influx.WaitFor(metrics.Measurement, 1, 5*time.Second)
+	if len(points) != 1 {
+		t.Fatalf("got %d %s points, want 1 per call", len(points), metrics.Measurement)
+	}
+	p := points[0]
+	wantTags := map[string]string{
+		"endpoint":    "/UserService/GetUser",
+		"status_code": "OK",
+		"side":        metrics.SideClient,
+		"target":      "bufnet",
+	}
+	for k, want := range wantTags {
+		if got := p.Tags[k]; got != want {
+			t.Errorf("tag %s = %q, want %q", k, got, want)
+		}
+	}
+	wantFields := map[string]interface{}{
+		"error":         false,
+		"retries":       int64(2),
+		"request_size":  int64(proto.Size(req)),
+		"response_size": int64(proto.Size(resp)),
+	}
+	for k, want := range wantFields {
+		if got := p.Fields[k]; got != want {
+			t.Errorf("field %s = %v (%T), want %v (%T)", k, got, got, want, want)
+		}
+	}
+	if id, _ := p.Fields["request_id"].(string); id == "" {
+		t.Error("field request_id is empty")
+	}
+}
+
+func TestClientDoesNotRetryWrites(t *testing.T) {
+	svc := &flakyUserService{}
+	users, influx := startFlakyServer(t, svc)
+
+	if _, err := users.CreateUser(context.Background(), &user.CreateUserRequest{}); grpcstatus.Code(err) != codes.Unavailable {
+		t.Fatalf("CreateUser error = %v, want Unavailable", err)
+	}
+	if got := svc.createCalls.Load(); got != 1 {
+		t.Errorf("server saw %d CreateUser calls, want 1", got)
+	}
+
+	points := influx.WaitFor(metrics.Measurement, 1, 5*time.Second)
+	if len(points) != 1 {
+		t.Fatalf("got %d %s points, want 1", len(points), metrics.Measurement)
+	}
+	p := points[0]
+	if p.Tags["status_code"] != "Unavailable" || p.Tags["endpoint"] != "/UserService/CreateUser" {
+		t.Errorf("tags = %v, want CreateUser with Unavailable", p.Tags)
+	}
+	if p.Fields["retries"] != int64(0) || p.Fields["error"] != true {
+		t.Errorf("fields = %v, want an error without retries", p.Fields)
+	}
+}
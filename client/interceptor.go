@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/metrics"
+	"github.com/Ling-Qingran/gRPC-Observability/requestid"
+	"github.com/Ling-Qingran/gRPC-Observability/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// UnaryClientInterceptor records the latency, status code, retries and
+// message sizes of a unary call as observed by the caller.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	attempts := new(int32)
+	ctx = context.WithValue(ctx, attemptsKey{}, attempts)
+
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	duration := time.Since(start)
+
+	respSize := 0
+	if err == nil {
+		respSize = messageSize(reply)
+	}
+	writeMetrics(ctx, duration, err, messageSize(req), respSize, method, cc.Target(), atomic.LoadInt32(attempts))
+	return err
+}
+
+// StreamClientInterceptor records a streaming call once it finishes, summing
+// the sizes of all messages sent and received on it.
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	attempts := new(int32)
+	ctx = context.WithValue(ctx, attemptsKey{}, attempts)
+
+	start := time.Now()
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		writeMetrics(ctx, time.Since(start), err, 0, 0, method, cc.Target(), atomic.LoadInt32(attempts))
+		return nil, err
+	}
+	return &clientStream{
+		ClientStream: cs,
+		ctx:          ctx,
+		desc:         desc,
+		method:       method,
+		target:       cc.Target(),
+		start:        start,
+		attempts:     attempts,
+	}, nil
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	ctx      context.Context
+	desc     *grpc.StreamDesc
+	method   string
+	target   string
+	start    time.Time
+	attempts *int32
+
+	mu       sync.Mutex
+	reqSize  int
+	respSize int
+	once     sync.Once
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.mu.Lock()
+		s.reqSize += messageSize(m)
+		s.mu.Unlock()
+	} else if err != io.EOF {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	default:
+		s.mu.Lock()
+		s.respSize += messageSize(m)
+		s.mu.Unlock()
+		// Streams without server streaming end after their single response.
+		if !s.desc.ServerStreams {
+			s.finish(nil)
+		}
+	}
+	return err
+}
+
+func (s *clientStream) finish(err error) {
+	s.once.Do(func() {
+		s.mu.Lock()
+		reqSize, respSize := s.reqSize, s.respSize
+		s.mu.Unlock()
+		writeMetrics(s.ctx, time.Since(s.start), err, reqSize, respSize, s.method, s.target, atomic.LoadInt32(s.attempts))
+	})
+}
+
+func writeMetrics(ctx context.Context, duration time.Duration, err error, reqSize, respSize int, method, target string, attempts int32) {
+	retries := 0
+	if attempts > 1 {
+		retries = int(attempts) - 1
+	}
+
+	errorRate := 0
+	if err != nil {
+		errorRate = 1
+	}
+
+	fields := map[string]interface{}{
+		"error":         err != nil,
+		"request_size":  reqSize,
+		"response_size": respSize,
+		"request_count": 1,
+		"error_rate":    errorRate,
+		"retries":       retries,
+		"request_id":    requestid.FromContext(ctx),
+	}
+	for k, v := range tracing.ExemplarFields(ctx) {
+		fields[k] = v
+	}
+
+	metrics.Write(
+		duration,
+		map[string]string{
+			"endpoint":    method,
+			"status_code": grpcstatus.Code(err).String(),
+			"side":        metrics.SideClient,
+			"target":      target,
+		},
+		fields,
+	)
+}
+
+func messageSize(m interface{}) int {
+	if msg, ok := m.(proto.Message); ok {
+		return proto.Size(msg)
+	}
+	return 0
+}
+
+type attemptsKey struct{}
+
+// attemptCounter is a stats handler that counts the transport attempts made
+// for each call, including retries driven by the service config, which are
+// otherwise invisible to interceptors.
+type attemptCounter struct{}
+
+func (attemptCounter) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	if attempts, ok := ctx.Value(attemptsKey{}).(*int32); ok {
+		atomic.AddInt32(attempts, 1)
+	}
+	return ctx
+}
+
+func (attemptCounter) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (attemptCounter) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (attemptCounter) HandleConn(context.Context, stats.ConnStats) {}
@@ -0,0 +1,73 @@
+// Command registry runs the reference service registry. Services register
+// over WebSocket at /register; instances are listed at /services and over the
+// ServiceRegistry gRPC service, and changes are pushed to /watch and Watch
+// subscribers. It does not authenticate services, so run it on a trusted
+// network only.
+//
+//	registry -addr :8090 -grpc-addr :8091
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/registry"
+	"github.com/Ling-Qingran/gRPC-Observability/registrypb"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	addr := flag.String("addr", ":8090", "address for the WebSocket and HTTP endpoints")
+	grpcAddr := flag.String("grpc-addr", ":8091", "address for the gRPC ServiceRegistry service (empty disables it)")
+	defaultTTL := flag.Duration("default-ttl", 90*time.Second, "lease of registrations that do not carry one")
+	flag.Parse()
+
+	reg := registry.NewServer()
+	reg.DefaultTTL = *defaultTTL
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	go reg.Run(ctx)
+
+	httpServer := &http.Server{Addr: *addr, Handler: reg.Handler()}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to serve HTTP: %v", err)
+		}
+	}()
+	log.Printf("Registry listening on %s", *addr)
+
+	var grpcServer *grpc.Server
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen: %v", err)
+		}
+		grpcServer = grpc.NewServer()
+		registrypb.RegisterServiceRegistryServer(grpcServer, reg.GRPC())
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Fatalf("Failed to serve gRPC: %v", err)
+			}
+		}()
+		log.Printf("Registry gRPC service listening on %s", *grpcAddr)
+	}
+
+	<-ctx.Done()
+	log.Printf("Shutting down")
+	// Watch streams never end on their own, so gRPC is stopped, not drained
+	if grpcServer != nil {
+		grpcServer.Stop()
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	httpServer.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,282 @@
+// Command replay re-sends the requests in a capture file to a server and
+// reports every response that differs from the recorded one.
+//
+//	replay -file captures.jsonl -target localhost:8080
+//
+// Only read-only methods are replayed unless -allow-writes is given, and
+// -dry-run lists what would be sent without connecting. Records whose request
+// had fields redacted at capture time are skipped, as they can no longer be
+// sent as recorded; the fields are named, and the summary counts skipped
+// records by reason.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/capture"
+	"github.com/Ling-Qingran/gRPC-Observability/redact"
+	_ "github.com/Ling-Qingran/gRPC-Observability/status"
+	_ "github.com/Ling-Qingran/gRPC-Observability/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+func main() {
+	file := flag.String("file", "captures.jsonl", "capture file to replay")
+	target := flag.String("target", "localhost:8080", "server to send the requests to")
+	method := flag.String("method", "", "only replay this full method, e.g. /UserService/GetUser")
+	timeout := flag.Duration("timeout", 10*time.Second, "per-call timeout")
+	allowWrites := flag.Bool("allow-writes", false, "also replay methods that change data, e.g. CreateUser")
+	dryRun := flag.Bool("dry-run", false, "list the records that would be replayed without sending them")
+	flag.Parse()
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatalf("Unable to open capture file: %v", err)
+	}
+	defer f.Close()
+
+	var conn *grpc.ClientConn
+	if !*dryRun {
+		conn, err = grpc.Dial(*target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			log.Fatalf("Unable to connect to %s: %v", *target, err)
+		}
+		defer conn.Close()
+	}
+
+	var replayed, mismatched int
+	skipped := map[string]int{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var rec capture.Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			fmt.Printf("line %d: skipped, invalid record: %v\n", line, err)
+			skipped["invalid record"]++
+			continue
+		}
+		if *method != "" && rec.Method != *method {
+			continue
+		}
+		if !*allowWrites && !readOnly(rec.Method) {
+			fmt.Printf("line %d %s: skipped, changes data (use -allow-writes)\n", line, rec.Method)
+			skipped["changes data"]++
+			continue
+		}
+		if fields := redacted("", rec.Request); len(fields) > 0 {
+			fmt.Printf("line %d %s: skipped, request redacted at capture time (%s; see capture.redact_fields)\n", line, rec.Method, strings.Join(fields, ", "))
+			skipped["redacted request"]++
+			continue
+		}
+		if *dryRun {
+			fmt.Printf("line %d %s: would replay %s\n", line, rec.Method, format(rec.Request))
+			replayed++
+			continue
+		}
+
+		diffs, err := replay(conn, rec, *timeout)
+		if err != nil {
+			fmt.Printf("line %d %s: skipped, %v\n", line, rec.Method, err)
+			skipped["not sendable"]++
+			continue
+		}
+		replayed++
+		if len(diffs) > 0 {
+			mismatched++
+			fmt.Printf("line %d %s (request_id %s):\n", line, rec.Method, rec.RequestID)
+			for _, d := range diffs {
+				fmt.Printf("  %s\n", d)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Unable to read capture file: %v", err)
+	}
+
+	if *dryRun {
+		fmt.Printf("would replay %d, skipped %s\n", replayed, skipSummary(skipped))
+		return
+	}
+	fmt.Printf("replayed %d, mismatched %d, skipped %s\n", replayed, mismatched, skipSummary(skipped))
+	if mismatched > 0 {
+		os.Exit(1)
+	}
+}
+
+// replay sends rec's request and returns how the outcome differs from the
+// recorded one.
+func replay(conn *grpc.ClientConn, rec capture.Record, timeout time.Duration) ([]string, error) {
+	md, err := findMethod(rec.Method)
+	if err != nil {
+		return nil, err
+	}
+	req, err := newMessage(md.Input())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := newMessage(md.Output())
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(rec.Request)
+	if err != nil {
+		return nil, err
+	}
+	if err := protojson.Unmarshal(body, req); err != nil {
+		return nil, fmt.Errorf("request cannot be rebuilt: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	callErr := conn.Invoke(ctx, rec.Method, req, resp)
+
+	var diffs []string
+	if code := status.Code(callErr).String(); code != rec.Code {
+		diffs = append(diffs, fmt.Sprintf("code: recorded %s, got %s", rec.Code, code))
+	}
+	if callErr == nil && rec.Response != nil {
+		diffs = append(diffs, diff("response", rec.Response, redact.Message(resp, nil))...)
+	}
+	return diffs, nil
+}
+
+// readOnlyPrefixes start the names of methods that do not change data.
+var readOnlyPrefixes = []string{"Get", "List", "Check", "Watch"}
+
+// readOnly reports whether fullMethod only reads data, judged by its name.
+func readOnly(fullMethod string) bool {
+	name := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	for _, p := range readOnlyPrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// redacted returns the paths below path of the values in v that were
+// replaced at capture time, sorted.
+func redacted(path string, v interface{}) []string {
+	var paths []string
+	switch v := v.(type) {
+	case string:
+		if v == redact.Value {
+			paths = append(paths, path)
+		}
+	case map[string]interface{}:
+		for k, inner := range v {
+			paths = append(paths, redacted(strings.TrimPrefix(path+"."+k, "."), inner)...)
+		}
+	case []interface{}:
+		for i, inner := range v {
+			paths = append(paths, redacted(fmt.Sprintf("%s[%d]", path, i), inner)...)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// skipSummary describes how many records were skipped and why, e.g.
+// "3 (changes data 1, redacted request 2)".
+func skipSummary(skipped map[string]int) string {
+	total := 0
+	reasons := make([]string, 0, len(skipped))
+	for reason, n := range skipped {
+		total += n
+		reasons = append(reasons, fmt.Sprintf("%s %d", reason, n))
+	}
+	if total == 0 {
+		return "0"
+	}
+	sort.Strings(reasons)
+	return fmt.Sprintf("%d (%s)", total, strings.Join(reasons, ", "))
+}
+
+func findMethod(fullMethod string) (protoreflect.MethodDescriptor, error) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return nil, fmt.Errorf("malformed method %q", fullMethod)
+	}
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("unknown service %q", service)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("unknown method %q", fullMethod)
+	}
+	return md, nil
+}
+
+func newMessage(d protoreflect.MessageDescriptor) (proto.Message, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(d.FullName())
+	if err != nil {
+		return nil, err
+	}
+	return mt.New().Interface(), nil
+}
+
+// diff lists the paths at which got differs from want. Values redacted at
+// capture time cannot be compared and are skipped.
+func diff(path string, want, got interface{}) []string {
+	if want == redact.Value {
+		return nil
+	}
+
+	wantMap, wantIsMap := want.(map[string]interface{})
+	gotMap, gotIsMap := got.(map[string]interface{})
+	if wantIsMap && gotIsMap {
+		keys := map[string]bool{}
+		for k := range wantMap {
+			keys[k] = true
+		}
+		for k := range gotMap {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		var diffs []string
+		for _, k := range sorted {
+			diffs = append(diffs, diff(path+"."+k, wantMap[k], gotMap[k])...)
+		}
+		return diffs
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		return []string{fmt.Sprintf("%s: recorded %v, got %v", path, format(want), format(got))}
+	}
+	return nil
+}
+
+func format(v interface{}) string {
+	if v == nil {
+		return "<unset>"
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
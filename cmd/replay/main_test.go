@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/capture"
+	"github.com/Ling-Qingran/gRPC-Observability/redact"
+	"github.com/Ling-Qingran/gRPC-Observability/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestReadOnly(t *testing.T) {
+	for method, want := range map[string]bool{
+		"/UserService/GetUser":       true,
+		"/StatusService/CheckStatus": true,
+		"/StatusService/WatchStatus": true,
+		"/UserService/CreateUser":    false,
+		"/UserService/UpdateUser":    false,
+		"/UserService/DeleteUser":    false,
+	} {
+		if got := readOnly(method); got != want {
+			t.Errorf("readOnly(%q) = %v, want %v", method, got, want)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	var req interface{}
+	json.Unmarshal([]byte(`{"name":"[REDACTED]","user":{"age":"25","hobbies":"[REDACTED]"},"tags":["a","[REDACTED]"]}`), &req)
+	want := []string{"name", "tags[1]", "user.hobbies"}
+	if got := redacted("", req); !reflect.DeepEqual(got, want) {
+		t.Errorf("redacted = %v, want %v", got, want)
+	}
+
+	json.Unmarshal([]byte(`{"name":"John Doe"}`), &req)
+	if got := redacted("", req); len(got) != 0 {
+		t.Errorf("redacted without redaction = %v, want none", got)
+	}
+}
+
+func TestSkipSummary(t *testing.T) {
+	if got := skipSummary(map[string]int{}); got != "0" {
+		t.Errorf("skipSummary(none) = %q, want 0", got)
+	}
+	got := skipSummary(map[string]int{"redacted request": 2, "changes data": 1})
+	if want := "3 (changes data 1, redacted request 2)"; got != want {
+		t.Errorf("skipSummary = %q, want %q", got, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	want := map[string]interface{}{"name": "John Doe", "age": "25", "hobbies": redact.Value}
+	got := map[string]interface{}{"name": "John Doe", "age": "26", "hobbies": "Reading", "college": "MIT"}
+	diffs := diff("response", want, got)
+	wantDiffs := []string{
+		`response.age: recorded "25", got "26"`,
+		`response.college: recorded <unset>, got "MIT"`,
+	}
+	if !reflect.DeepEqual(diffs, wantDiffs) {
+		t.Errorf("diff = %q, want %q", diffs, wantDiffs)
+	}
+}
+
+type stubUserService struct {
+	user.UnimplementedUserServiceServer
+}
+
+func (stubUserService) GetUser(_ context.Context, req *user.GetUserRequest) (*user.User, error) {
+	if req.GetName() != "John Doe" {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	return &user.User{Name: "John Doe", Age: "26"}, nil
+}
+
+func TestReplay(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	user.RegisterUserServiceServer(s, stubUserService{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	for _, tc := range []struct {
+		name string
+		rec  capture.Record
+		want []string
+	}{
+		{"same", capture.Record{
+			Method: "/UserService/GetUser", Code: "OK",
+			Request:  map[string]interface{}{"name": "John Doe"},
+			Response: map[string]interface{}{"name": "John Doe", "age": redact.Value},
+		}, nil},
+		{"changed response", capture.Record{
+			Method: "/UserService/GetUser", Code: "OK",
+			Request:  map[string]interface{}{"name": "John Doe"},
+			Response: map[string]interface{}{"name": "John Doe", "age": "25"},
+		}, []string{`response.age: recorded "25", got "26"`}},
+		{"changed code", capture.Record{
+			Method: "/UserService/GetUser", Code: "OK",
+			Request: map[string]interface{}{"name": "Nobody"},
+		}, []string{"code: recorded OK, got NotFound"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			diffs, err := replay(conn, tc.rec, 5*time.Second)
+			if err != nil {
+				t.Fatalf("replay: %v", err)
+			}
+			if !reflect.DeepEqual(diffs, tc.want) {
+				t.Errorf("diffs = %q, want %q", diffs, tc.want)
+			}
+		})
+	}
+
+	if _, err := replay(conn, capture.Record{Method: "/UserService/Nope"}, time.Second); err == nil {
+		t.Error("replay of an unknown method succeeded")
+	}
+}
@@ -0,0 +1,407 @@
+// Package config loads the server configuration. Settings are layered, each
+// overriding the one before: built-in defaults, a YAML or JSON file, environment
+// variables and command-line flags. The result is validated as a whole before
+// the server uses any of it.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/metrics"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete server configuration.
+type Config struct {
+	// File is the file the configuration was loaded from, if any.
+	File string `yaml:"-"`
+	// Port is the port the gRPC server listens on.
+	Port int `yaml:"port"`
+	// LogLevel is the minimum level of access log lines: debug, info, warn
+	// or error.
+	LogLevel string `yaml:"log_level"`
+	// ShutdownTimeout bounds how long in-flight calls may take to finish
+	// after SIGTERM before they are cancelled.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// TrustedProxies lists the addresses or CIDRs of proxies whose
+	// X-Forwarded-For, Forwarded and X-Real-IP headers are believed when
+	// tagging and logging the client address. Headers from other peers are
+	// ignored.
+	TrustedProxies []string        `yaml:"trusted_proxies"`
+	Service        Service         `yaml:"service"`
+	Registry       Registry        `yaml:"registry"`
+	Sheets         Sheets          `yaml:"sheets"`
+	InfluxDB       metrics.Sink    `yaml:"influxdb"`
+	Metrics        metrics.Options `yaml:"metrics"`
+	AccessLog      AccessLog       `yaml:"access_log"`
+	Tracing        Tracing         `yaml:"tracing"`
+	Capture        Capture         `yaml:"capture"`
+	Health         Health          `yaml:"health"`
+	Probes         Probes          `yaml:"probes"`
+}
+
+// Service is how the server announces itself to the registry.
+type Service struct {
+	Name string `yaml:"name"`
+	Host string `yaml:"host"`
+	// Port is the advertised port, which differs from the listen port behind
+	// a proxy. Zero means the listen port.
+	Port int    `yaml:"port"`
+	Type string `yaml:"type"`
+	// InstanceID tells this instance apart from others of the same service.
+	// Empty means a random ID chosen at startup.
+	InstanceID string `yaml:"instance_id"`
+	Region     string `yaml:"region"`
+	Zone       string `yaml:"zone"`
+	// TLS advertises that the port expects TLS, e.g. when a proxy in front
+	// of the server terminates it.
+	TLS  bool              `yaml:"tls"`
+	Tags map[string]string `yaml:"tags"`
+}
+
+// Registry locates the service registry and sets how the connection to it is
+// kept alive.
+type Registry struct {
+	// Backend is how the service registers: "websocket" keeps a connection
+	// to the registry at URL, "http" posts to it, and "file" keeps an SRV
+	// record in File.
+	Backend string `yaml:"backend"`
+	URL     string `yaml:"url"`
+	File    string `yaml:"file"`
+	// HeartbeatInterval is the time between heartbeats on the connection.
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+	// MaxBackoff caps the delay between reconnects.
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+	// TTL is the lease sent with the registration: the registry drops the
+	// service after hearing nothing from it for this long.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+func (r Registry) validate() error {
+	scheme := func(schemes ...string) error {
+		u, err := url.Parse(r.URL)
+		if err == nil && u.Host != "" {
+			for _, s := range schemes {
+				if u.Scheme == s {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("registry.url %q is not a %s:// or %s:// URL", r.URL, schemes[0], schemes[1])
+	}
+	switch r.Backend {
+	case "websocket":
+		return scheme("ws", "wss")
+	case "http":
+		return scheme("http", "https")
+	case "file":
+		if r.File == "" {
+			return fmt.Errorf("registry.file must be set for the file backend")
+		}
+		return nil
+	}
+	return fmt.Errorf("registry.backend %q is not websocket, http or file", r.Backend)
+}
+
+// Sheets locates the spreadsheet holding users and the OAuth files used to
+// reach it.
+type Sheets struct {
+	SpreadsheetID string `yaml:"spreadsheet_id"`
+	// SheetID is the numeric ID of the sheet rows are deleted from.
+	SheetID         int64  `yaml:"sheet_id"`
+	ReadRange       string `yaml:"read_range"`
+	WriteRange      string `yaml:"write_range"`
+	CredentialsFile string `yaml:"credentials_file"`
+	TokenFile       string `yaml:"token_file"`
+}
+
+// AccessLog controls the per-call access log.
+type AccessLog struct {
+	// SampleRate is the fraction of successful, fast calls that are logged.
+	SampleRate float64 `yaml:"sample_rate"`
+	// SlowThreshold marks calls at least this slow, which are always logged.
+	// Zero disables it.
+	SlowThreshold time.Duration `yaml:"slow_threshold"`
+	// RequestBody adds the request message to each line, with RedactFields
+	// masked.
+	RequestBody  bool     `yaml:"request_body"`
+	RedactFields []string `yaml:"redact_fields"`
+}
+
+// piiFields are the user fields that identify or describe a person. They
+// are redacted by default wherever request bodies are written out.
+var piiFields = []string{"name", "age", "commute_method", "college", "hobbies"}
+
+// captureRedactFields are the piiFields but name, which captures keep by
+// default: it is the key every UserService request is made with, so without
+// it no captured call could be replayed.
+var captureRedactFields = []string{"age", "commute_method", "college", "hobbies"}
+
+// Tracing controls which traces are recorded.
+type Tracing struct {
+	// SampleRate is the fraction of traces started by this server that are
+	// sampled. Traces continued from a caller keep the caller's decision.
+	SampleRate float64 `yaml:"sample_rate"`
+}
+
+// Capture controls payload capture. An empty File disables it. The user
+// fields but name are redacted by default; records with redacted requests
+// cannot be replayed.
+type Capture struct {
+	File         string   `yaml:"file"`
+	SampleRate   float64  `yaml:"sample_rate"`
+	RedactFields []string `yaml:"redact_fields"`
+}
+
+// Health controls the dependency probes behind the gRPC health service.
+type Health struct {
+	// ProbeInterval is the time between probes of each dependency.
+	ProbeInterval time.Duration `yaml:"probe_interval"`
+	// ProbeTimeout bounds a single probe.
+	ProbeTimeout time.Duration `yaml:"probe_timeout"`
+}
+
+// Probes controls the HTTP liveness and readiness endpoints.
+type Probes struct {
+	// Port serves /livez and /readyz. Zero disables them.
+	Port int `yaml:"port"`
+}
+
+// Default returns the configuration used when nothing overrides it.
+func Default() Config {
+	return Config{
+		Port:     8080,
+		LogLevel: "info",
+		// Cloud Run allows 10s after SIGTERM; leave time for the final flush
+		ShutdownTimeout: 8 * time.Second,
+		Service: Service{
+			Name: "Student-Info gRPC Service Cloud",
+			Host: "localhost",
+			Type: "gRPC",
+		},
+		Registry: Registry{
+			Backend:           "websocket",
+			URL:               "ws://localhost:8090/register",
+			HeartbeatInterval: 10 * time.Second,
+			MaxBackoff:        time.Minute,
+			TTL:               30 * time.Second,
+		},
+		Sheets: Sheets{
+			SpreadsheetID:   "10-CfbfktbeTSMV3tgnIKwaBquzw-RmjS13Tut9A32_s",
+			ReadRange:       "Sheet1",
+			WriteRange:      "Sheet1",
+			CredentialsFile: "credentials.json",
+			TokenFile:       "token.json",
+		},
+		Metrics:   metrics.DefaultOptions(),
+		AccessLog: AccessLog{SampleRate: 1, SlowThreshold: time.Second, RedactFields: piiFields},
+		Tracing:   Tracing{SampleRate: 1},
+		Capture:   Capture{SampleRate: 1, RedactFields: captureRedactFields},
+		Health:    Health{ProbeInterval: 15 * time.Second, ProbeTimeout: 5 * time.Second},
+		Probes:    Probes{Port: 8081},
+	}
+}
+
+// Load builds the configuration from args (without the program name), the
+// environment and the file named by -config or CONFIG_FILE. printConfig
+// reports whether -print-config was given.
+func Load(args []string) (cfg Config, printConfig bool, err error) {
+	cfg = Default()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or JSON configuration file")
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective configuration with secrets masked and exit")
+	overrides := defineFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return cfg, false, err
+	}
+
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return cfg, false, err
+		}
+		cfg.File = *path
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, false, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if apply, ok := overrides[f.Name]; ok {
+			apply(&cfg)
+		}
+	})
+
+	if cfg.Service.Port == 0 {
+		cfg.Service.Port = cfg.Port
+	}
+	if cfg.Service.InstanceID == "" {
+		cfg.Service.InstanceID = instanceID
+	}
+	return cfg, printConfig, cfg.Validate()
+}
+
+// instanceID is the random instance ID, fixed for the life of the process so
+// that reloads do not change it.
+var instanceID = uuid.NewString()
+
+// loadFile merges the file at path over c. Unknown keys are rejected so that
+// a misspelt setting does not silently keep its default.
+func (c *Config) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks the configuration. The InfluxDB sink, including its token
+// file, is left out: an unusable sink disables metrics rather than stopping
+// the server.
+func (c Config) Validate() error {
+	for name, port := range map[string]int{"port": c.Port, "service.port": c.Service.Port} {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("%s %d is not a valid port", name, port)
+		}
+	}
+	for name, v := range map[string]string{
+		"service.name":            c.Service.Name,
+		"service.host":            c.Service.Host,
+		"service.type":            c.Service.Type,
+		"sheets.spreadsheet_id":   c.Sheets.SpreadsheetID,
+		"sheets.read_range":       c.Sheets.ReadRange,
+		"sheets.write_range":      c.Sheets.WriteRange,
+		"sheets.credentials_file": c.Sheets.CredentialsFile,
+		"sheets.token_file":       c.Sheets.TokenFile,
+	} {
+		if v == "" {
+			return fmt.Errorf("%s must be set", name)
+		}
+	}
+	if err := c.Registry.validate(); err != nil {
+		return err
+	}
+	for _, p := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			return fmt.Errorf("trusted_proxies: %q is not an address or CIDR", p)
+		}
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown_timeout %v must be positive", c.ShutdownTimeout)
+	}
+	if _, err := c.Level(); err != nil {
+		return err
+	}
+	if c.Registry.HeartbeatInterval <= 0 || c.Registry.MaxBackoff < time.Second {
+		return fmt.Errorf("registry.heartbeat_interval must be positive and registry.max_backoff at least 1s")
+	}
+	if c.Registry.TTL < 2*c.Registry.HeartbeatInterval {
+		return fmt.Errorf("registry.ttl %v must be at least twice registry.heartbeat_interval %v so one lost heartbeat does not expire the lease", c.Registry.TTL, c.Registry.HeartbeatInterval)
+	}
+	if err := c.Metrics.Validate(); err != nil {
+		return fmt.Errorf("metrics: %w", err)
+	}
+	if c.AccessLog.SampleRate < 0 || c.AccessLog.SampleRate > 1 {
+		return fmt.Errorf("access_log.sample_rate %v is not between 0 and 1", c.AccessLog.SampleRate)
+	}
+	if c.AccessLog.SlowThreshold < 0 {
+		return fmt.Errorf("access_log.slow_threshold %v is negative", c.AccessLog.SlowThreshold)
+	}
+	if c.Tracing.SampleRate < 0 || c.Tracing.SampleRate > 1 {
+		return fmt.Errorf("tracing.sample_rate %v is not between 0 and 1", c.Tracing.SampleRate)
+	}
+	if c.Capture.SampleRate < 0 || c.Capture.SampleRate > 1 {
+		return fmt.Errorf("capture.sample_rate %v is not between 0 and 1", c.Capture.SampleRate)
+	}
+	if c.Probes.Port < 0 || c.Probes.Port > 65535 {
+		return fmt.Errorf("probes.port %d is not a valid port", c.Probes.Port)
+	}
+	if c.Probes.Port != 0 && c.Probes.Port == c.Port {
+		return fmt.Errorf("probes.port %d is also the gRPC port", c.Probes.Port)
+	}
+	if c.Health.ProbeInterval <= 0 || c.Health.ProbeTimeout <= 0 {
+		return fmt.Errorf("health.probe_interval and health.probe_timeout must be positive")
+	}
+	return nil
+}
+
+// Level parses LogLevel.
+func (c Config) Level() (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return 0, fmt.Errorf("log_level %q is not debug, info, warn or error", c.LogLevel)
+	}
+	return l, nil
+}
+
+// RestartRequired lists the sections that differ between c and next but are
+// only read at startup. Log level, trusted proxies, metrics, access log and
+// tracing settings can be changed while running; everything else needs a
+// restart to take effect.
+func (c Config) RestartRequired(next Config) []string {
+	var changed []string
+	for name, pair := range map[string][2]interface{}{
+		"port":             {c.Port, next.Port},
+		"shutdown_timeout": {c.ShutdownTimeout, next.ShutdownTimeout},
+		"service":          {c.Service, next.Service},
+		"registry":         {c.Registry, next.Registry},
+		"sheets":           {c.Sheets, next.Sheets},
+		"influxdb":         {c.InfluxDB, next.InfluxDB},
+		"capture":          {c.Capture, next.Capture},
+		"health":           {c.Health, next.Health},
+		"probes":           {c.Probes, next.Probes},
+	} {
+		if !reflect.DeepEqual(pair[0], pair[1]) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// WithReloadable returns c with the sections that can change while running
+// taken from next: what is in effect after next is applied without a
+// restart.
+func (c Config) WithReloadable(next Config) Config {
+	c.LogLevel = next.LogLevel
+	c.TrustedProxies = next.TrustedProxies
+	c.Metrics = next.Metrics
+	c.AccessLog = next.AccessLog
+	c.Tracing = next.Tracing
+	return c
+}
+
+// maskedValue stands in for secrets in printed configuration.
+const maskedValue = "****"
+
+// Print writes c as YAML with secrets masked.
+func (c Config) Print(w io.Writer) error {
+	if c.InfluxDB.Token != "" {
+		c.InfluxDB.Token = maskedValue
+	}
+	if c.Metrics.Tags.IPHashSalt != "" {
+		c.Metrics.Tags.IPHashSalt = maskedValue
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes a configuration file and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, printConfig, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if printConfig {
+		t.Error("printConfig set without -print-config")
+	}
+	if cfg.Port != 8080 || cfg.Service.Port != 8080 || cfg.Service.InstanceID == "" {
+		t.Errorf("defaults: port %d, service port %d, instance %q", cfg.Port, cfg.Service.Port, cfg.Service.InstanceID)
+	}
+	// Captures keep the name, which replaying needs
+	for _, f := range cfg.Capture.RedactFields {
+		if f == "name" {
+			t.Error("captures redact name by default")
+		}
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "server.yaml", `
+port: 9001
+log_level: warn
+service:
+  host: from-file
+  region: file-region
+  zone: file-zone
+registry:
+  heartbeat_interval: 5s
+`)
+
+	// Each layer overrides the one before for the settings it names and
+	// leaves the others alone
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("PORT", "9002")
+	t.Setenv("SERVICE_HOST", "from-env")
+	t.Setenv("SERVICE_REGION", "env-region")
+	cfg, _, err := Load([]string{"-port", "9003", "-region", "flag-region"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	for name, got := range map[string][2]interface{}{
+		"port (flag over env over file)": {cfg.Port, 9003},
+		"region (flag over env)":         {cfg.Service.Region, "flag-region"},
+		"host (env over file)":           {cfg.Service.Host, "from-env"},
+		"zone (file over default)":       {cfg.Service.Zone, "file-zone"},
+		"log level (file)":               {cfg.LogLevel, "warn"},
+		"heartbeat (file)":               {cfg.Registry.HeartbeatInterval, 5 * time.Second},
+		"ttl (default)":                  {cfg.Registry.TTL, 30 * time.Second},
+		"service port (follows port)":    {cfg.Service.Port, 9003},
+		"file":                           {cfg.File, path},
+	} {
+		if got[0] != got[1] {
+			t.Errorf("%s = %v, want %v", name, got[0], got[1])
+		}
+	}
+}
+
+func TestLoadConfigFlagOverridesEnv(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeFile(t, "env.yaml", "port: 9001\n"))
+	cfg, _, err := Load([]string{"-config", writeFile(t, "flag.yaml", "port: 9002\n")})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Port != 9002 {
+		t.Errorf("port = %d, want 9002 from the -config file", cfg.Port)
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	path := writeFile(t, "server.yaml", "port: 9001\nregistry:\n  hearbeat_interval: 5s\n")
+	_, _, err := Load([]string{"-config", path})
+	if err == nil || !strings.Contains(err.Error(), "hearbeat_interval") {
+		t.Errorf("Load with a misspelt key: err = %v, want one naming it", err)
+	}
+}
+
+func TestLoadRejectsBadEnv(t *testing.T) {
+	for name, value := range map[string]string{
+		"PORT":                "eighty",
+		"METRICS_SAMPLE_RATE": "half",
+		"SERVICE_TAGS":        "team",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, _, err := Load(nil); err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("Load with %s=%q: err = %v, want one naming it", name, value, err)
+			}
+		})
+	}
+}
+
+func TestMetricsEnv(t *testing.T) {
+	t.Setenv("METRICS_SAMPLE_RATE", "0.25")
+	t.Setenv("METRICS_ALLOW_ENDPOINT", "/UserService/GetUser, /UserService/CreateUser")
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Metrics.Sampling.Rate != 0.25 {
+		t.Errorf("sampling rate = %v, want 0.25", cfg.Metrics.Sampling.Rate)
+	}
+	if want := []string{"/UserService/GetUser", "/UserService/CreateUser"}; !reflect.DeepEqual(cfg.Metrics.Tags.Allowlist["endpoint"], want) {
+		t.Errorf("endpoint allowlist = %v, want %v", cfg.Metrics.Tags.Allowlist["endpoint"], want)
+	}
+}
+
+func TestInfluxTokenPrecedence(t *testing.T) {
+	tokenFile := writeFile(t, "token", "from-token-file\n")
+	path := writeFile(t, "server.yaml", "influxdb:\n  url: http://localhost:8086\n  token: from-config\n")
+
+	resolve := func(args ...string) string {
+		t.Helper()
+		cfg, _, err := Load(append([]string{"-config", path}, args...))
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		sink, err := cfg.InfluxDB.ResolveToken()
+		if err != nil {
+			t.Fatalf("ResolveToken: %v", err)
+		}
+		return sink.Token
+	}
+
+	if got := resolve(); got != "from-config" {
+		t.Errorf("token = %q, want the file's", got)
+	}
+	t.Setenv("INFLUXDB_TOKEN_FILE", tokenFile)
+	if got := resolve(); got != "from-token-file" {
+		t.Errorf("token with INFLUXDB_TOKEN_FILE = %q, want the token file's", got)
+	}
+	t.Setenv("INFLUXDB_TOKEN", "from-env")
+	if got := resolve(); got != "from-env" {
+		t.Errorf("token with INFLUXDB_TOKEN = %q, want the variable's", got)
+	}
+	if got := resolve("-influxdb-token-file", tokenFile); got != "from-token-file" {
+		t.Errorf("token with -influxdb-token-file = %q, want the token file's", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(*Config)
+		want   string
+	}{
+		{"valid", func(*Config) {}, ""},
+		{"port", func(c *Config) { c.Port = 70000 }, "port 70000"},
+		{"missing name", func(c *Config) { c.Service.Name = "" }, "service.name"},
+		{"registry scheme", func(c *Config) { c.Registry.URL = "http://localhost:8090/register" }, "registry.url"},
+		{"http backend", func(c *Config) { c.Registry.Backend, c.Registry.URL = "http", "http://localhost:8090/register" }, ""},
+		{"file backend without file", func(c *Config) { c.Registry.Backend = "file" }, "registry.file"},
+		{"unknown backend", func(c *Config) { c.Registry.Backend = "consul" }, "registry.backend"},
+		{"short ttl", func(c *Config) { c.Registry.TTL = c.Registry.HeartbeatInterval }, "registry.ttl"},
+		{"log level", func(c *Config) { c.LogLevel = "loud" }, "log_level"},
+		{"access log rate", func(c *Config) { c.AccessLog.SampleRate = 2 }, "access_log.sample_rate"},
+		{"slow threshold", func(c *Config) { c.AccessLog.SlowThreshold = -time.Second }, "access_log.slow_threshold"},
+		{"tracing rate", func(c *Config) { c.Tracing.SampleRate = -1 }, "tracing.sample_rate"},
+		{"metrics", func(c *Config) { c.Metrics.Tags.IPMode = "exact" }, "metrics"},
+		{"probe port clash", func(c *Config) { c.Probes.Port = c.Port }, "probes.port"},
+		{"trusted proxy", func(c *Config) { c.TrustedProxies = []string{"lb.internal"} }, "trusted_proxies"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Service.Port = cfg.Port
+			tc.change(&cfg)
+			err := cfg.Validate()
+			switch {
+			case tc.want == "" && err != nil:
+				t.Errorf("Validate: %v", err)
+			case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+				t.Errorf("Validate = %v, want an error mentioning %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	cfg := Default()
+	next := Default()
+	next.LogLevel = "debug"
+	next.Metrics.Sampling.Rate = 0.5
+	next.Port = 9000
+	next.Registry.TTL = time.Minute
+	if got, want := cfg.RestartRequired(next), []string{"port", "registry"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RestartRequired = %v, want %v", got, want)
+	}
+}
+
+func TestPrintMasksSecrets(t *testing.T) {
+	t.Setenv("INFLUXDB_URL", "http://influx.internal:8086")
+	t.Setenv("INFLUXDB_TOKEN", "secret-token")
+	t.Setenv("METRICS_IP_HASH_SALT", "secret-salt")
+	cfg, printConfig, err := Load([]string{"-print-config"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !printConfig {
+		t.Error("printConfig not set by -print-config")
+	}
+
+	var b strings.Builder
+	if err := cfg.Print(&b); err != nil {
+		t.Fatalf("Print: %v", err)
+	}
+	out := b.String()
+	for _, secret := range []string{"secret-token", "secret-salt"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("printed configuration contains %q:\n%s", secret, out)
+		}
+	}
+	if n := strings.Count(out, maskedValue); n != 2 {
+		t.Errorf("printed configuration has %d masked values, want 2:\n%s", n, out)
+	}
+	if !strings.Contains(out, "http://influx.internal:8086") {
+		t.Errorf("printed configuration lacks the InfluxDB URL:\n%s", out)
+	}
+	if cfg.InfluxDB.Token != "secret-token" {
+		t.Errorf("Print changed the token to %q", cfg.InfluxDB.Token)
+	}
+
+	// Unset secrets are not shown as masked
+	b.Reset()
+	if err := Default().Print(&b); err != nil {
+		t.Fatalf("Print: %v", err)
+	}
+	if strings.Contains(b.String(), maskedValue) {
+		t.Errorf("defaults printed with masked values:\n%s", b.String())
+	}
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"flag"
+
+	"github.com/Ling-Qingran/gRPC-Observability/internal/env"
+)
+
+// applyEnv overrides c with the environment variables that are set. A
+// variable that is set but cannot be parsed is an error.
+func (c *Config) applyEnv() error {
+	e := env.Reader{}
+
+	e.Int("PORT", &c.Port)
+	e.String("LOG_LEVEL", &c.LogLevel)
+	e.Duration("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+	e.List("TRUSTED_PROXIES", &c.TrustedProxies)
+	e.String("SERVICE_NAME", &c.Service.Name)
+	e.String("SERVICE_HOST", &c.Service.Host)
+	e.Int("SERVICE_PORT", &c.Service.Port)
+	e.String("SERVICE_TYPE", &c.Service.Type)
+	e.String("SERVICE_INSTANCE_ID", &c.Service.InstanceID)
+	e.String("SERVICE_REGION", &c.Service.Region)
+	e.String("SERVICE_ZONE", &c.Service.Zone)
+	e.Bool("SERVICE_TLS", &c.Service.TLS)
+	e.Pairs("SERVICE_TAGS", &c.Service.Tags)
+	e.String("REGISTRY_BACKEND", &c.Registry.Backend)
+	e.String("REGISTRY_URL", &c.Registry.URL)
+	e.String("REGISTRY_FILE", &c.Registry.File)
+	e.Duration("REGISTRY_HEARTBEAT_INTERVAL", &c.Registry.HeartbeatInterval)
+	e.Duration("REGISTRY_MAX_BACKOFF", &c.Registry.MaxBackoff)
+	e.Duration("REGISTRY_TTL", &c.Registry.TTL)
+
+	e.String("SPREADSHEET_ID", &c.Sheets.SpreadsheetID)
+	e.Int64("SHEET_ID", &c.Sheets.SheetID)
+	e.String("SHEETS_READ_RANGE", &c.Sheets.ReadRange)
+	e.String("SHEETS_WRITE_RANGE", &c.Sheets.WriteRange)
+	e.String("GOOGLE_CREDENTIALS_FILE", &c.Sheets.CredentialsFile)
+	e.String("GOOGLE_TOKEN_FILE", &c.Sheets.TokenFile)
+
+	c.InfluxDB = c.InfluxDB.WithEnv()
+
+	m, err := c.Metrics.WithEnv()
+	if err != nil && e.Err == nil {
+		e.Err = err
+	}
+	c.Metrics = m
+
+	e.Float("ACCESS_LOG_SAMPLE_RATE", &c.AccessLog.SampleRate)
+	e.Duration("ACCESS_LOG_SLOW_THRESHOLD", &c.AccessLog.SlowThreshold)
+	e.Bool("ACCESS_LOG_REQUEST_BODY", &c.AccessLog.RequestBody)
+	e.List("ACCESS_LOG_REDACT_FIELDS", &c.AccessLog.RedactFields)
+
+	e.Float("TRACING_SAMPLE_RATE", &c.Tracing.SampleRate)
+
+	e.String("CAPTURE_FILE", &c.Capture.File)
+	e.Float("CAPTURE_SAMPLE_RATE", &c.Capture.SampleRate)
+	e.List("CAPTURE_REDACT_FIELDS", &c.Capture.RedactFields)
+
+	e.Duration("HEALTH_PROBE_INTERVAL", &c.Health.ProbeInterval)
+	e.Duration("HEALTH_PROBE_TIMEOUT", &c.Health.ProbeTimeout)
+	e.Int("PROBE_PORT", &c.Probes.Port)
+
+	return e.Err
+}
+
+// defineFlags registers the command-line overrides on fs. The returned
+// functions apply a flag to a Config and are only called for flags that were
+// given, so an unset flag never masks the file or environment.
+func defineFlags(fs *flag.FlagSet) map[string]func(*Config) {
+	d := Default()
+	port := fs.Int("port", d.Port, "port to listen on")
+	probePort := fs.Int("probe-port", d.Probes.Port, "port serving /livez and /readyz (0 disables)")
+	logLevel := fs.String("log-level", d.LogLevel, "minimum access log level: debug, info, warn or error")
+	serviceName := fs.String("service-name", d.Service.Name, "service name announced to the registry")
+	serviceHost := fs.String("service-host", d.Service.Host, "host announced to the registry")
+	servicePort := fs.Int("service-port", 0, "port announced to the registry (default: -port)")
+	serviceType := fs.String("service-type", d.Service.Type, "service type announced to the registry")
+	instanceID := fs.String("instance-id", "", "instance ID announced to the registry (default: random)")
+	region := fs.String("region", "", "region announced to the registry")
+	zone := fs.String("zone", "", "zone announced to the registry")
+	registryBackend := fs.String("registry-backend", d.Registry.Backend, "registration backend: websocket, http or file")
+	registryURL := fs.String("registry-url", d.Registry.URL, "URL of the service registry, for the websocket and http backends")
+	registryFile := fs.String("registry-file", "", "SRV record file, for the file backend")
+	spreadsheetID := fs.String("spreadsheet-id", d.Sheets.SpreadsheetID, "ID of the spreadsheet holding users")
+	sheetID := fs.Int64("sheet-id", d.Sheets.SheetID, "numeric ID of the sheet rows are deleted from")
+	readRange := fs.String("read-range", d.Sheets.ReadRange, "range users are read from")
+	writeRange := fs.String("write-range", d.Sheets.WriteRange, "range users are appended to")
+	credentialsFile := fs.String("credentials-file", d.Sheets.CredentialsFile, "Google OAuth client secret file")
+	tokenFile := fs.String("token-file", d.Sheets.TokenFile, "Google OAuth token cache file")
+	influxURL := fs.String("influxdb-url", "", "InfluxDB URL; metrics are disabled when empty")
+	influxOrg := fs.String("influxdb-org", "", "InfluxDB organization")
+	influxBucket := fs.String("influxdb-bucket", "", "InfluxDB bucket")
+	influxTokenFile := fs.String("influxdb-token-file", "", "file holding the InfluxDB token")
+
+	return map[string]func(*Config){
+		"port":                func(c *Config) { c.Port = *port },
+		"log-level":           func(c *Config) { c.LogLevel = *logLevel },
+		"probe-port":          func(c *Config) { c.Probes.Port = *probePort },
+		"service-name":        func(c *Config) { c.Service.Name = *serviceName },
+		"service-host":        func(c *Config) { c.Service.Host = *serviceHost },
+		"service-port":        func(c *Config) { c.Service.Port = *servicePort },
+		"service-type":        func(c *Config) { c.Service.Type = *serviceType },
+		"instance-id":         func(c *Config) { c.Service.InstanceID = *instanceID },
+		"region":              func(c *Config) { c.Service.Region = *region },
+		"zone":                func(c *Config) { c.Service.Zone = *zone },
+		"registry-backend":    func(c *Config) { c.Registry.Backend = *registryBackend },
+		"registry-url":        func(c *Config) { c.Registry.URL = *registryURL },
+		"registry-file":       func(c *Config) { c.Registry.File = *registryFile },
+		"spreadsheet-id":      func(c *Config) { c.Sheets.SpreadsheetID = *spreadsheetID },
+		"sheet-id":            func(c *Config) { c.Sheets.SheetID = *sheetID },
+		"read-range":          func(c *Config) { c.Sheets.ReadRange = *readRange },
+		"write-range":         func(c *Config) { c.Sheets.WriteRange = *writeRange },
+		"credentials-file":    func(c *Config) { c.Sheets.CredentialsFile = *credentialsFile },
+		"token-file":          func(c *Config) { c.Sheets.TokenFile = *tokenFile },
+		"influxdb-url":        func(c *Config) { c.InfluxDB.URL = *influxURL },
+		"influxdb-org":        func(c *Config) { c.InfluxDB.Org = *influxOrg },
+		"influxdb-bucket":     func(c *Config) { c.InfluxDB.Bucket = *influxBucket },
+		"influxdb-token-file": func(c *Config) { c.InfluxDB.TokenFile = *influxTokenFile; c.InfluxDB.Token = "" },
+	}
+}
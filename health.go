@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/config"
+	"github.com/Ling-Qingran/gRPC-Observability/metrics"
+	"github.com/Ling-Qingran/gRPC-Observability/registry"
+	"github.com/Ling-Qingran/gRPC-Observability/status"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// dependency is a backend the server relies on.
+type dependency struct {
+	// name is also registered as a health service, so each dependency can be
+	// checked on its own.
+	name string
+	// probe checks the dependency. Dependencies without a probe are checked
+	// by their own code, which reports the outcome with healthProber.report.
+	probe func(ctx context.Context) error
+	// services are the health services that are only SERVING while this
+	// dependency is healthy. "" is the server as a whole, which makes the
+	// dependency required.
+	services []string
+}
+
+func (d dependency) required() bool {
+	for _, svc := range d.services {
+		if svc == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// dependencyState is the outcome of the last check of a dependency.
+type dependencyState struct {
+	checked     time.Time
+	lastSuccess time.Time
+	err         error
+}
+
+// healthProber probes every dependency periodically and sets the status of
+// the health services from the results.
+type healthProber struct {
+	server *health.Server
+	deps   []dependency
+	cfg    config.Health
+
+	mu     sync.Mutex
+	states map[string]dependencyState
+	// changed is closed and replaced whenever a dependency turns healthy or
+	// unhealthy.
+	changed chan struct{}
+}
+
+// newHealthProber returns a prober for deps. grpc's health server starts with
+// "" SERVING, so every service the dependencies gate, and every dependency,
+// is set to NOT_SERVING until its first check says otherwise.
+func newHealthProber(server *health.Server, cfg config.Health, deps ...dependency) *healthProber {
+	for _, d := range deps {
+		server.SetServingStatus(d.name, healthpb.HealthCheckResponse_NOT_SERVING)
+		for _, svc := range d.services {
+			server.SetServingStatus(svc, healthpb.HealthCheckResponse_NOT_SERVING)
+		}
+	}
+	return &healthProber{server: server, deps: deps, cfg: cfg, states: map[string]dependencyState{}, changed: make(chan struct{})}
+}
+
+// watch returns a channel that is closed at the next change.
+func (p *healthProber) watch() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.changed
+}
+
+// wake wakes up the watchers for a change outside the dependencies, such as
+// the server starting to drain.
+func (p *healthProber) wake() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.notify()
+}
+
+// notify wakes up the watchers. p.mu must be held.
+func (p *healthProber) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// run probes immediately and then every ProbeInterval until ctx is done.
+func (p *healthProber) run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		p.probeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *healthProber) probeAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, d := range p.deps {
+		if d.probe == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(d dependency) {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, p.cfg.ProbeTimeout)
+			defer cancel()
+			p.report(d.name, d.probe(probeCtx))
+		}(d)
+	}
+	wg.Wait()
+}
+
+// report records the outcome of checking the named dependency and updates
+// the health services that depend on it.
+func (p *healthProber) report(name string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	prev, seen := p.states[name]
+	if !seen || (prev.err == nil) != (err == nil) {
+		if err != nil {
+			log.Printf("Health: %s is unhealthy: %v", name, err)
+		} else {
+			log.Printf("Health: %s is healthy", name)
+		}
+		defer p.notify()
+	}
+	state := dependencyState{checked: time.Now(), lastSuccess: prev.lastSuccess, err: err}
+	if err == nil {
+		state.lastSuccess = state.checked
+	}
+	p.states[name] = state
+	p.server.SetServingStatus(name, servingStatus(err))
+
+	// A service is SERVING only once all of its dependencies have been
+	// checked and are healthy
+	serving := map[string]bool{}
+	for _, d := range p.deps {
+		s, checked := p.states[d.name]
+		for _, svc := range d.services {
+			if _, ok := serving[svc]; !ok {
+				serving[svc] = true
+			}
+			serving[svc] = serving[svc] && checked && s.err == nil
+		}
+	}
+	for svc, ok := range serving {
+		if ok {
+			p.server.SetServingStatus(svc, healthpb.HealthCheckResponse_SERVING)
+		} else {
+			p.server.SetServingStatus(svc, healthpb.HealthCheckResponse_NOT_SERVING)
+		}
+	}
+}
+
+// warmedUp reports whether every required dependency has been checked at
+// least once.
+func (p *healthProber) warmedUp() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, d := range p.deps {
+		if _, checked := p.states[d.name]; d.required() && !checked {
+			return false
+		}
+	}
+	return true
+}
+
+// state summarizes the dependencies: NOT_SERVING if a required one is
+// failing or not checked yet, DEGRADED if an optional one is failing, and
+// SERVING otherwise. A disabled metrics sink is not a failure.
+func (p *healthProber) state() status.State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state := status.State_SERVING
+	for _, d := range p.deps {
+		s, checked := p.states[d.name]
+		switch {
+		case d.required() && !checked:
+			return status.State_NOT_SERVING
+		case s.err == nil || errors.Is(s.err, metrics.ErrSinkDisabled):
+		case d.required():
+			return status.State_NOT_SERVING
+		default:
+			state = status.State_DEGRADED
+		}
+	}
+	return state
+}
+
+// registryHealth is the health sent with registry heartbeats.
+func (p *healthProber) registryHealth() registry.Health {
+	h := registry.Health{State: p.state().String()}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, d := range p.deps {
+		if err := p.states[d.name].err; err != nil && !errors.Is(err, metrics.ErrSinkDisabled) {
+			h.Unhealthy = append(h.Unhealthy, d.name)
+		}
+	}
+	return h
+}
+
+// dependencies describes the last check of every dependency.
+func (p *healthProber) dependencies() []*status.Dependency {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	deps := make([]*status.Dependency, 0, len(p.deps))
+	for _, d := range p.deps {
+		s, checked := p.states[d.name]
+		dep := &status.Dependency{Name: d.name, Healthy: checked && s.err == nil, Required: d.required()}
+		if !s.checked.IsZero() {
+			dep.LastChecked = timestamppb.New(s.checked)
+		}
+		if !s.lastSuccess.IsZero() {
+			dep.LastSuccess = timestamppb.New(s.lastSuccess)
+		}
+		if s.err != nil {
+			dep.Error = s.err.Error()
+		}
+		deps = append(deps, dep)
+	}
+	return deps
+}
+
+// servingStatus maps a probe result to a health status. A disabled metrics
+// sink is a deliberate configuration, not a failure, so it is reported as
+// unknown rather than not serving.
+func servingStatus(err error) healthpb.HealthCheckResponse_ServingStatus {
+	switch {
+	case err == nil:
+		return healthpb.HealthCheckResponse_SERVING
+	case errors.Is(err, metrics.ErrSinkDisabled):
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+	default:
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+}
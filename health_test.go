@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Ling-Qingran/gRPC-Observability/config"
+	"github.com/Ling-Qingran/gRPC-Observability/metrics"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func servingStatusOf(t *testing.T, server *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatalf("Check(%q): %v", service, err)
+	}
+	return resp.Status
+}
+
+func TestHealthProberTransitions(t *testing.T) {
+	const (
+		notServing = healthpb.HealthCheckResponse_NOT_SERVING
+		serving    = healthpb.HealthCheckResponse_SERVING
+		unknown    = healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+	)
+	server := health.NewServer()
+	p := newHealthProber(server, config.Health{},
+		dependency{name: "storage", services: []string{"", "users"}},
+		dependency{name: "cache", services: []string{"users"}},
+		dependency{name: "metrics"},
+	)
+
+	for _, step := range []struct {
+		name   string
+		dep    string
+		err    error
+		status map[string]healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{"before the first probe", "", nil, map[string]healthpb.HealthCheckResponse_ServingStatus{
+			"": notServing, "users": notServing, "storage": notServing, "cache": notServing, "metrics": notServing,
+		}},
+		{"storage healthy, cache unchecked", "storage", nil, map[string]healthpb.HealthCheckResponse_ServingStatus{
+			"": serving, "users": notServing, "storage": serving, "cache": notServing,
+		}},
+		{"cache healthy", "cache", nil, map[string]healthpb.HealthCheckResponse_ServingStatus{
+			"": serving, "users": serving, "cache": serving,
+		}},
+		{"storage failing", "storage", errors.New("down"), map[string]healthpb.HealthCheckResponse_ServingStatus{
+			"": notServing, "users": notServing, "storage": notServing, "cache": serving,
+		}},
+		{"metrics disabled", "metrics", metrics.ErrSinkDisabled, map[string]healthpb.HealthCheckResponse_ServingStatus{
+			"": notServing, "metrics": unknown,
+		}},
+		{"storage recovered", "storage", nil, map[string]healthpb.HealthCheckResponse_ServingStatus{
+			"": serving, "users": serving, "storage": serving,
+		}},
+	} {
+		if step.dep != "" {
+			p.report(step.dep, step.err)
+		}
+		for svc, want := range step.status {
+			if got := servingStatusOf(t, server, svc); got != want {
+				t.Errorf("%s: %q is %v, want %v", step.name, svc, got, want)
+			}
+		}
+	}
+}
+
+func TestHealthProberWarmUp(t *testing.T) {
+	p := newHealthProber(health.NewServer(), config.Health{},
+		dependency{name: "storage", services: []string{""}},
+		dependency{name: "metrics"},
+	)
+	if p.warmedUp() {
+		t.Error("warmed up before any check")
+	}
+	p.report("metrics", nil)
+	if p.warmedUp() {
+		t.Error("warmed up before the required dependency was checked")
+	}
+	p.report("storage", errors.New("down"))
+	if !p.warmedUp() {
+		t.Error("not warmed up after every required dependency was checked")
+	}
+}
@@ -0,0 +1,159 @@
+package influxtest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point is one parsed line of line protocol. Field values are float64, int64,
+// uint64, string or bool, following the type suffixes of the line.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// ParseLine parses a single line of InfluxDB line protocol:
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+//
+// precision is the write precision ("ns", "us", "ms" or "s"; "" means ns).
+func ParseLine(line, precision string) (Point, error) {
+	p := Point{Tags: map[string]string{}, Fields: map[string]interface{}{}}
+
+	series, rest, err := splitUnescaped(line, ' ', false)
+	if err != nil {
+		return p, err
+	}
+	fieldSet, timestamp, err := splitUnescaped(rest, ' ', true)
+	if err != nil {
+		return p, err
+	}
+
+	parts, err := splitAll(series, ',', false)
+	if err != nil {
+		return p, err
+	}
+	p.Measurement = unescape(parts[0])
+	if p.Measurement == "" {
+		return p, fmt.Errorf("missing measurement in %q", line)
+	}
+	for _, tag := range parts[1:] {
+		k, v, err := splitUnescaped(tag, '=', false)
+		if err != nil || k == "" || v == "" {
+			return p, fmt.Errorf("invalid tag %q", tag)
+		}
+		p.Tags[unescape(k)] = unescape(v)
+	}
+
+	fields, err := splitAll(fieldSet, ',', true)
+	if err != nil {
+		return p, err
+	}
+	for _, field := range fields {
+		k, v, err := splitUnescaped(field, '=', true)
+		if err != nil || k == "" || v == "" {
+			return p, fmt.Errorf("invalid field %q", field)
+		}
+		value, err := parseFieldValue(v)
+		if err != nil {
+			return p, fmt.Errorf("field %q: %v", k, err)
+		}
+		p.Fields[unescape(k)] = value
+	}
+	if len(p.Fields) == 0 {
+		return p, fmt.Errorf("no fields in %q", line)
+	}
+
+	if timestamp = strings.TrimSpace(timestamp); timestamp != "" {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+		unit := time.Nanosecond
+		switch precision {
+		case "us":
+			unit = time.Microsecond
+		case "ms":
+			unit = time.Millisecond
+		case "s":
+			unit = time.Second
+		}
+		p.Time = time.Unix(0, ts*int64(unit))
+	}
+	return p, nil
+}
+
+func parseFieldValue(v string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		if len(v) < 2 || !strings.HasSuffix(v, `"`) {
+			return nil, fmt.Errorf("unterminated string %s", v)
+		}
+		r := strings.NewReplacer(`\"`, `"`, `\\`, `\`)
+		return r.Replace(v[1 : len(v)-1]), nil
+	case strings.HasSuffix(v, "i"):
+		return strconv.ParseInt(v[:len(v)-1], 10, 64)
+	case strings.HasSuffix(v, "u"):
+		return strconv.ParseUint(v[:len(v)-1], 10, 64)
+	}
+	switch v {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+// splitUnescaped splits s at the first sep that is neither backslash-escaped
+// nor, when quotes is set, inside a double-quoted string.
+func splitUnescaped(s string, sep byte, quotes bool) (string, string, error) {
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"' && quotes:
+			inQuote = !inQuote
+		case c == sep && !inQuote:
+			return s[:i], s[i+1:], nil
+		}
+	}
+	if inQuote {
+		return "", "", fmt.Errorf("unterminated string in %q", s)
+	}
+	return s, "", nil
+}
+
+func splitAll(s string, sep byte, quotes bool) ([]string, error) {
+	var parts []string
+	for {
+		part, rest, err := splitUnescaped(s, sep, quotes)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+		if len(rest) == 0 && len(part) == len(s) {
+			return parts, nil
+		}
+		s = rest
+	}
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
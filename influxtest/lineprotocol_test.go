@@ -0,0 +1,56 @@
+package influxtest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want Point
+	}{
+		{
+			line: `gRPCMetrics,endpoint=/UserService/GetUser,side=server duration=0.25,error=false,request_count=1i 1700000000000000000`,
+			want: Point{
+				Measurement: "gRPCMetrics",
+				Tags:        map[string]string{"endpoint": "/UserService/GetUser", "side": "server"},
+				Fields:      map[string]interface{}{"duration": 0.25, "error": false, "request_count": int64(1)},
+				Time:        time.Unix(0, 1700000000000000000),
+			},
+		},
+		{
+			line: `my\ measurement,user_agent=grpc-go/1.59\,\ x,k\=1=v count=7u,msg="say \"hi\", then=go"`,
+			want: Point{
+				Measurement: "my measurement",
+				Tags:        map[string]string{"user_agent": "grpc-go/1.59, x", "k=1": "v"},
+				Fields:      map[string]interface{}{"count": uint64(7), "msg": `say "hi", then=go`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseLine(tt.line, "")
+		if err != nil {
+			t.Errorf("ParseLine(%q): %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseLine(%q) =\n%+v\nwant\n%+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseLineErrors(t *testing.T) {
+	for _, line := range []string{
+		`gRPCMetrics`,
+		`gRPCMetrics,endpoint duration=1`,
+		`gRPCMetrics msg="unterminated`,
+		`gRPCMetrics duration=abc`,
+		`gRPCMetrics duration=1 notatime`,
+	} {
+		if _, err := ParseLine(line, ""); err == nil {
+			t.Errorf("ParseLine(%q) succeeded, want error", line)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Package influxtest provides an in-process stand-in for the InfluxDB v2 write
+// endpoint. Tests point the metrics sink at it and inspect the points that
+// would have been stored.
+package influxtest
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server accepts line protocol writes for one org and bucket.
+type Server struct {
+	URL    string
+	Org    string
+	Bucket string
+	Token  string
+
+	srv    *httptest.Server
+	mu     sync.Mutex
+	points []Point
+	added  chan struct{}
+}
+
+// NewServer starts a server that accepts writes to org/bucket authenticated
+// with token. Callers must Close it.
+func NewServer(org, bucket, token string) *Server {
+	s := &Server{Org: org, Bucket: bucket, Token: token, added: make(chan struct{}, 1)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/write", s.handleWrite)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"name": "influxdb", "status": "pass"})
+	})
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Points returns a copy of every point written so far.
+func (s *Server) Points() []Point {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Point(nil), s.points...)
+}
+
+// Reset discards the points written so far.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.points = nil
+}
+
+// WaitFor returns the points in measurement once at least n have arrived, or
+// whatever has arrived when timeout expires. Writes are asynchronous in the
+// InfluxDB client, so tests should not read Points straight after a call.
+func (s *Server) WaitFor(measurement string, n int, timeout time.Duration) []Point {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		var matched []Point
+		for _, p := range s.Points() {
+			if p.Measurement == measurement {
+				matched = append(matched, p)
+			}
+		}
+		if len(matched) >= n {
+			return matched
+		}
+		select {
+		case <-s.added:
+		case <-deadline.C:
+			return matched
+		}
+	}
+}
+
+func (s *Server) handleWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed", "write requires POST")
+		return
+	}
+	if r.Header.Get("Authorization") != "Token "+s.Token {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "unauthorized access")
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("org") != s.Org {
+		writeError(w, http.StatusNotFound, "not found", `organization name "`+q.Get("org")+`" not found`)
+		return
+	}
+	if q.Get("bucket") != s.Bucket {
+		writeError(w, http.StatusNotFound, "not found", `bucket "`+q.Get("bucket")+`" not found`)
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+
+	var points []Point
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p, err := ParseLine(line, q.Get("precision"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		points = append(points, p)
+	}
+
+	s.mu.Lock()
+	s.points = append(s.points, points...)
+	s.mu.Unlock()
+	select {
+	case s.added <- struct{}{}:
+	default:
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]string{"code": code, "message": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,140 @@
+// Package env parses environment variables into typed settings. It is shared
+// by the configuration and the metrics options, which clients read without
+// the rest of the configuration.
+package env
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reader parses environment variables into typed fields. Variables that are
+// unset or empty leave the field alone; the first one that cannot be parsed
+// is kept in Err and the field is left alone.
+type Reader struct {
+	Err error
+}
+
+// Lookup returns the value of the named variable and whether it is set and
+// not empty.
+func (r *Reader) Lookup(name string) (string, bool) {
+	v, ok := os.LookupEnv(name)
+	return v, ok && v != ""
+}
+
+// Fail records that name=v cannot be parsed, unless an error was recorded
+// already.
+func (r *Reader) Fail(name, v string, err error) {
+	if r.Err == nil {
+		r.Err = fmt.Errorf("environment variable %s=%q: %v", name, v, err)
+	}
+}
+
+// String sets dst to the named variable.
+func (r *Reader) String(name string, dst *string) {
+	if v, ok := r.Lookup(name); ok {
+		*dst = v
+	}
+}
+
+// Int parses the named variable as an int.
+func (r *Reader) Int(name string, dst *int) {
+	if v, ok := r.Lookup(name); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			r.Fail(name, v, err)
+			return
+		}
+		*dst = n
+	}
+}
+
+// Int64 parses the named variable as an int64.
+func (r *Reader) Int64(name string, dst *int64) {
+	if v, ok := r.Lookup(name); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			r.Fail(name, v, err)
+			return
+		}
+		*dst = n
+	}
+}
+
+// Float parses the named variable as a float64.
+func (r *Reader) Float(name string, dst *float64) {
+	if v, ok := r.Lookup(name); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			r.Fail(name, v, err)
+			return
+		}
+		*dst = f
+	}
+}
+
+// Bool parses the named variable with strconv.ParseBool.
+func (r *Reader) Bool(name string, dst *bool) {
+	if v, ok := r.Lookup(name); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			r.Fail(name, v, err)
+			return
+		}
+		*dst = b
+	}
+}
+
+// Duration parses the named variable with time.ParseDuration.
+func (r *Reader) Duration(name string, dst *time.Duration) {
+	if v, ok := r.Lookup(name); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			r.Fail(name, v, err)
+			return
+		}
+		*dst = d
+	}
+}
+
+// List parses a comma-separated list and reports whether the variable was set.
+func (r *Reader) List(name string, dst *[]string) bool {
+	v, ok := r.Lookup(name)
+	if !ok {
+		return false
+	}
+	*dst = SplitList(v)
+	return true
+}
+
+// Pairs parses "key=value,key=value".
+func (r *Reader) Pairs(name string, dst *map[string]string) {
+	v, ok := r.Lookup(name)
+	if !ok {
+		return
+	}
+	pairs := map[string]string{}
+	for _, pair := range SplitList(v) {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			r.Fail(name, v, fmt.Errorf("%q is not key=value", pair))
+			return
+		}
+		pairs[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	*dst = pairs
+}
+
+// SplitList splits a comma-separated list, dropping blank items.
+func SplitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -6,53 +6,124 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/Ling-Qingran/gRPC-Observability/capture"
+	"github.com/Ling-Qingran/gRPC-Observability/config"
+	"github.com/Ling-Qingran/gRPC-Observability/metrics"
+	"github.com/Ling-Qingran/gRPC-Observability/redact"
+	"github.com/Ling-Qingran/gRPC-Observability/registry"
+	"github.com/Ling-Qingran/gRPC-Observability/requestid"
 	"github.com/Ling-Qingran/gRPC-Observability/status"
+	"github.com/Ling-Qingran/gRPC-Observability/storage"
+	"github.com/Ling-Qingran/gRPC-Observability/tracing"
 	"github.com/Ling-Qingran/gRPC-Observability/user"
-	"github.com/gorilla/websocket"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 type userServiceServer struct {
 	user.UnimplementedUserServiceServer
+	store  *storage.Sheets
+	sheets config.Sheets
+	client *http.Client
 }
 type statusServiceServer struct {
 	status.UnimplementedStatusServiceServer
+	// draining is set once shutdown starts, while in-flight calls finish.
+	draining atomic.Bool
+	health   *healthProber
+}
+
+// statusNames are the legacy status strings for each state.
+var statusNames = map[status.State]string{
+	status.State_SERVING:     "Up",
+	status.State_DEGRADED:    "Degraded",
+	status.State_DRAINING:    "Draining",
+	status.State_NOT_SERVING: "Down",
 }
 
 func (s *statusServiceServer) CheckStatus(ctx context.Context, in *status.StatusRequest) (*status.StatusResponse, error) {
-	return &status.StatusResponse{Status: "Up"}, nil
+	return s.current(), nil
 }
 
-type Registration struct {
-	Name string `json:"name"`
-	Host string `json:"host"`
-	Port int    `json:"port"`
-	Type string `json:"type"`
+// WatchStatus streams the status on every change and a heartbeat in between.
+func (s *statusServiceServer) WatchStatus(in *status.WatchStatusRequest, stream status.StatusService_WatchStatusServer) error {
+	interval := 15 * time.Second
+	if in.GetHeartbeatInterval() != nil {
+		interval = max(in.GetHeartbeatInterval().AsDuration(), time.Second)
+	}
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+
+	// Watch before reading so a change in between is not missed
+	changed := s.health.watch()
+	resp := s.current()
+	for {
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+		if resp.State == status.State_DRAINING {
+			// Let GracefulStop finish instead of waiting for the client
+			return nil
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-changed:
+			changed = s.health.watch()
+			resp = s.current()
+			heartbeat.Reset(interval)
+		case <-heartbeat.C:
+			resp = s.current()
+			resp.Heartbeat = true
+		}
+	}
 }
 
-const (
-	spreadsheetID = "10-CfbfktbeTSMV3tgnIKwaBquzw-RmjS13Tut9A32_s"
-	readRange     = "Sheet1"
-	writeRange    = "Sheet1"
-)
+// drain marks the server as draining and tells the watchers.
+func (s *statusServiceServer) drain() {
+	s.draining.Store(true)
+	s.health.wake()
+}
 
-var srv *sheets.Service
+// current describes the server as CheckStatus and the HTTP probes report it.
+func (s *statusServiceServer) current() *status.StatusResponse {
+	state := s.health.state()
+	if s.draining.Load() {
+		state = status.State_DRAINING
+	}
+	return &status.StatusResponse{
+		Status:       statusNames[state],
+		State:        state,
+		Version:      version,
+		Commit:       commit,
+		StartTime:    timestamppb.New(startTime),
+		Uptime:       durationpb.New(time.Since(startTime)),
+		Dependencies: s.health.dependencies(),
+	}
+}
 
 // Retrieve a token, saves the token, then returns the generated client.
-func getClient(config *oauth2.Config) *http.Client {
-	// The file token.json stores the user's access and refresh tokens, and is
+func getClient(config *oauth2.Config, tokFile string) *http.Client {
+	// The token file stores the user's access and refresh tokens, and is
 	// created automatically when the authorization flow completes for the first
 	// time.
-	tokFile := "token.json"
 	tok, err := tokenFromFile(tokFile)
 	if err != nil {
 		tok = getTokenFromWeb(config)
@@ -102,31 +173,40 @@ func saveToken(path string, token *oauth2.Token) {
 	json.NewEncoder(f).Encode(token)
 }
 
-func init() {
+// newUserServiceServer connects to the spreadsheet backing the UserService.
+// It runs from main rather than init so that tests can load the package
+// without credentials.
+func newUserServiceServer(cfg config.Sheets) *userServiceServer {
 	ctx := context.Background()
-	b, err := os.ReadFile("credentials.json")
+	b, err := os.ReadFile(cfg.CredentialsFile)
 	if err != nil {
 		log.Fatalf("Unable to read client secret file: %v", err)
 	}
 
-	// If modifying these scopes, delete your previously saved token.json.
+	// If modifying these scopes, delete your previously saved token file.
 	config, err := google.ConfigFromJSON(b, "https://www.googleapis.com/auth/spreadsheets")
 	if err != nil {
 		log.Fatalf("Unable to parse client secret file to config: %v", err)
 	}
-	client := getClient(config)
+	client := getClient(config, cfg.TokenFile)
 
-	srv, err = sheets.NewService(ctx, option.WithHTTPClient(client))
+	srv, err := sheets.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
 		log.Fatalf("Unable to retrieve Sheets client: %v", err)
 	}
+	return &userServiceServer{store: storage.NewSheets(srv, cfg.SpreadsheetID), sheets: cfg, client: client}
+}
+
+// Close releases the connections to the Sheets API.
+func (s *userServiceServer) Close() {
+	s.client.CloseIdleConnections()
 }
 
 func (s *userServiceServer) GetUser(ctx context.Context, req *user.GetUserRequest) (*user.User, error) {
 	name := req.GetName()
 
 	// Get users from Google Sheets
-	resp, err := srv.Spreadsheets.Values.Get(spreadsheetID, readRange).Do()
+	resp, err := s.store.Get(ctx, s.sheets.ReadRange)
 	if err != nil {
 		return nil, err
 	}
@@ -153,7 +233,7 @@ func (s *userServiceServer) GetUser(ctx context.Context, req *user.GetUserReques
 func (s *userServiceServer) UpdateUser(ctx context.Context, req *user.UpdateUserRequest) (*user.User, error) {
 	name := req.GetName()
 
-	rowNumber, err := getRowNumberByName(name)
+	rowNumber, err := s.getRowNumberByName(ctx, name)
 	if err != nil {
 		return nil, err
 	}
@@ -172,8 +252,8 @@ func (s *userServiceServer) UpdateUser(ctx context.Context, req *user.UpdateUser
 		Values: [][]interface{}{rowData},
 	}
 
-	updateRange := fmt.Sprintf("Sheet1!A%d:E%d", rowNumber, rowNumber) // Assuming data starts in column A and spans 5 columns
-	_, err = srv.Spreadsheets.Values.Update(spreadsheetID, updateRange, valueRange).ValueInputOption("RAW").Do()
+	updateRange := fmt.Sprintf("%s!A%d:E%d", sheetName(s.sheets.WriteRange), rowNumber, rowNumber) // Assuming data starts in column A and spans 5 columns
+	err = s.store.Update(ctx, updateRange, valueRange)
 	if err != nil {
 		return nil, err
 	}
@@ -181,8 +261,14 @@ func (s *userServiceServer) UpdateUser(ctx context.Context, req *user.UpdateUser
 	return updatedUser, nil
 }
 
-func getRowNumberByName(name string) (int, error) {
-	resp, err := srv.Spreadsheets.Values.Get(spreadsheetID, readRange).Do() // Assuming name is in column A
+// sheetName returns the sheet part of an A1 range such as "Sheet1!A:E".
+func sheetName(a1Range string) string {
+	name, _, _ := strings.Cut(a1Range, "!")
+	return name
+}
+
+func (s *userServiceServer) getRowNumberByName(ctx context.Context, name string) (int, error) {
+	resp, err := s.store.Get(ctx, s.sheets.ReadRange) // Assuming name is in column A
 	if err != nil {
 		return -1, err
 	}
@@ -199,7 +285,7 @@ func getRowNumberByName(name string) (int, error) {
 func (s *userServiceServer) DeleteUser(ctx context.Context, req *user.DeleteUserRequest) (*user.DeleteUserResponse, error) {
 	name := req.GetName()
 
-	rowNumber, err := getRowNumberByName(name)
+	rowNumber, err := s.getRowNumberByName(ctx, name)
 	if err != nil {
 		return &user.DeleteUserResponse{Success: false}, err
 	}
@@ -209,7 +295,7 @@ func (s *userServiceServer) DeleteUser(ctx context.Context, req *user.DeleteUser
 	deleteRequest := &sheets.Request{
 		DeleteDimension: &sheets.DeleteDimensionRequest{
 			Range: &sheets.DimensionRange{
-				SheetId:    0, // Assuming you're working with the first sheet
+				SheetId:    s.sheets.SheetID,
 				Dimension:  "ROWS",
 				StartIndex: int64(rowNumber - 1), // -1 because sheet indexing starts at 0
 				EndIndex:   int64(rowNumber),
@@ -221,7 +307,7 @@ func (s *userServiceServer) DeleteUser(ctx context.Context, req *user.DeleteUser
 		Requests: []*sheets.Request{deleteRequest},
 	}
 
-	_, err = srv.Spreadsheets.BatchUpdate(spreadsheetID, batchUpdateRequest).Context(ctx).Do()
+	err = s.store.BatchUpdate(ctx, batchUpdateRequest)
 	if err != nil {
 		return &user.DeleteUserResponse{Success: false}, err
 	}
@@ -245,7 +331,7 @@ func (s *userServiceServer) CreateUser(ctx context.Context, req *user.CreateUser
 	}
 
 	// Append the data to Google Sheets
-	_, err := srv.Spreadsheets.Values.Append(spreadsheetID, writeRange, valueRange).ValueInputOption("RAW").Do()
+	err := s.store.Append(ctx, s.sheets.WriteRange, valueRange)
 	if err != nil {
 		return nil, err
 	}
@@ -253,79 +339,194 @@ func (s *userServiceServer) CreateUser(ctx context.Context, req *user.CreateUser
 	return newUser, nil
 }
 
-func registerWithRegistry(name, host string, port int, servType string) {
-	//registryURL := "wss://centralreg-necuf5ddgq-ue.a.run.app/register" // WebSocket URL
-	registryURL := "ws://localhost:8090/register" // WebSocket URL
-	registrationData := Registration{
-		Name: name,
-		Host: host,
-		Port: port,
-		Type: servType,
-	}
-
-	jsonData, err := json.Marshal(registrationData)
+func main() {
+	cfg, printConfig, err := config.Load(os.Args[1:])
 	if err != nil {
-		fmt.Println("Error marshalling registration data:", err)
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatalf("Failed to print configuration: %v", err)
+		}
 		return
 	}
 
-	ticker := time.NewTicker(30 * time.Second) // Retry every 30 seconds
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			c, _, err := websocket.DefaultDialer.Dial(registryURL, nil)
-			if err != nil {
-				fmt.Println("Error connecting to WebSocket, retrying...:", err)
-				continue
-			}
-
-			err = c.WriteMessage(websocket.TextMessage, jsonData)
-			if err != nil {
-				fmt.Println("Error sending registration data, retrying...:", err)
-				c.Close()
-				continue
-			}
-
-			_, message, err := c.ReadMessage()
-			if err != nil {
-				fmt.Println("Error reading response, retrying...:", err)
-			} else {
-				fmt.Printf("Response from server: %s\n", message)
-			}
-			c.Close()
-		}
+	if err := applyReloadable(cfg); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
-}
 
-func main() {
+	// Log level, metrics and access log settings follow the configuration
+	// without a restart
+	reloader := newReloader(os.Args[1:], cfg)
+	go reloader.watchSignals()
+	if cfg.File != "" {
+		go reloader.watchFile(cfg.File)
+	}
 
-	serviceName := "Student-Info gRPC Service Cloud"
-	//serviceHost := "grpc-observability-qimqpkozfa-ue.a.run.app"
-	serviceHost := "localhost"
-	//servicePort := 443
-	servicePort := 8080
-	serviceType := "gRPC"
+	// Metrics are optional: without a valid sink the server runs without them
+	sink, err := cfg.InfluxDB.ResolveToken()
+	if err == nil {
+		err = sink.Validate()
+	}
+	switch {
+	case err != nil:
+		log.Printf("Metrics disabled: %v", err)
+		sink = metrics.Sink{}
+	case !sink.Enabled():
+		log.Printf("Metrics disabled: no InfluxDB sink configured")
+	default:
+		log.Printf("Writing metrics to %s", sink)
+	}
+	metrics.SetSink(sink)
 
-	// Register your service with the registry
-	go registerWithRegistry(serviceName, serviceHost, servicePort, serviceType)
+	users := newUserServiceServer(cfg.Sheets)
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080" // default port if not specified
-	}
-	lis, err := net.Listen("tcp", ":"+port)
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Port))
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer(grpc.UnaryInterceptor(MetricsInterceptor))
+	unary := []grpc.UnaryServerInterceptor{requestid.UnaryServerInterceptor, tracing.UnaryServerInterceptor}
 
-	user.RegisterUserServiceServer(s, &userServiceServer{})
-	status.RegisterStatusServiceServer(s, &statusServiceServer{})
+	// Payload capture is opt-in: it writes request and response bodies to disk
+	if cfg.Capture.File != "" {
+		recorder, err := capture.NewRecorder(cfg.Capture.File, cfg.Capture.SampleRate, redact.NewFields(cfg.Capture.RedactFields))
+		if err != nil {
+			log.Fatalf("Failed to open capture file: %v", err)
+		}
+		defer recorder.Close()
+		unary = append(unary, recorder.UnaryServerInterceptor)
+	}
 
-	if err := s.Serve(lis); err != nil {
+	// Innermost, so their durations leave out the capture write; metrics,
+	// innermost of all, time the handler alone, while the access log also
+	// covers the metrics write, which only queues the point
+	unary = append(unary, AccessLogInterceptor, MetricsInterceptor)
+
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(requestid.StreamServerInterceptor, tracing.StreamServerInterceptor),
+	)
+
+	// The health service follows periodic probes of the backends: the server
+	// and UserService need the spreadsheet, while metrics are optional
+	healthServer := health.NewServer()
+	prober := newHealthProber(healthServer, cfg.Health,
+		dependency{name: "storage", probe: users.store.Ping, services: []string{"", user.UserService_ServiceDesc.ServiceName}},
+		dependency{name: "metrics", probe: metrics.Ping},
+		dependency{name: "registry"},
+	)
+	healthServer.SetServingStatus(status.StatusService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	probes, stopProbes := context.WithCancel(context.Background())
+	go prober.run(probes)
+
+	statusServer := &statusServiceServer{health: prober}
+	user.RegisterUserServiceServer(s, users)
+	status.RegisterStatusServiceServer(s, statusServer)
+	healthpb.RegisterHealthServer(s, healthServer)
+
+	// Register your service with the registry and keep the registration alive,
+	// once every service is registered on s, so the catalog is complete
+	opts := registry.DefaultOptions()
+	opts.HeartbeatInterval = cfg.Registry.HeartbeatInterval
+	opts.MaxBackoff = cfg.Registry.MaxBackoff
+	opts.Health = prober.registryHealth
+	opts.OnStatus = func(err error) { prober.report("registry", err) }
+	registrar := newRegistrar(cfg.Registry, registry.Registration{
+		Name:       cfg.Service.Name,
+		Host:       cfg.Service.Host,
+		Port:       cfg.Service.Port,
+		Type:       cfg.Service.Type,
+		Version:    version,
+		InstanceID: cfg.Service.InstanceID,
+		Region:     cfg.Service.Region,
+		Zone:       cfg.Service.Zone,
+		TLS:        cfg.Service.TLS,
+		Tags:       cfg.Service.Tags,
+		Services:   registry.ServicesOf(s),
+		// Rounded up so a sub-second lease is not sent as none
+		TTLSeconds: int((cfg.Registry.TTL + time.Second - 1) / time.Second),
+	}, opts)
+	registration, deregister := context.WithCancel(context.Background())
+	registered := make(chan struct{})
+	go func() {
+		registrar.Run(registration)
+		close(registered)
+	}()
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- s.Serve(lis) }()
+
+	// HTTP liveness and readiness probes, on their own port
+	var probeServer *http.Server
+	if cfg.Probes.Port != 0 {
+		probeServer = &http.Server{Addr: fmt.Sprintf(":%d", cfg.Probes.Port), Handler: newProbeHandler(statusServer)}
+		go func() {
+			if err := probeServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				serveErr <- err
+			}
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-serveErr:
 		log.Fatalf("Failed to serve: %v", err)
+	case sig := <-stop:
+		log.Printf("Received %v, shutting down", sig)
+	}
+
+	// Stop announcing the service first so that no new traffic is routed
+	// here, then let in-flight calls finish before flushing what they recorded
+	deregister()
+	<-registered
+	statusServer.drain()
+	stopProbes()
+	healthServer.Shutdown()
+	gracefulStop(s, cfg.ShutdownTimeout)
+	if probeServer != nil {
+		// Kept up while draining so that readiness reports it
+		probeServer.Close()
+	}
+	metrics.Flush()
+	users.Close()
+	log.Printf("Shutdown complete")
+}
+
+// gracefulStop stops s once in-flight calls finish, cancelling any still
+// running after timeout.
+func gracefulStop(s *grpc.Server, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		log.Printf("Calls still running after %v, cancelling them", timeout)
+		s.Stop()
+		<-done
+	}
+}
+
+// newRegistrar returns the registrar for the configured backend.
+func newRegistrar(cfg config.Registry, reg registry.Registration, opts registry.Options) registry.Registrar {
+	switch cfg.Backend {
+	case "http":
+		r := registry.NewHTTPRegistrar(cfg.URL, reg)
+		r.Options = opts
+		return r
+	case "file":
+		r := registry.NewFileRegistrar(cfg.File, reg)
+		r.Options = opts
+		return r
 	}
+	r := registry.NewClient(cfg.URL, reg)
+	r.Options = opts
+	return r
 }
@@ -2,24 +2,16 @@ package main
 
 import (
 	"context"
-	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/Ling-Qingran/gRPC-Observability/metrics"
+	"github.com/Ling-Qingran/gRPC-Observability/requestid"
+	"github.com/Ling-Qingran/gRPC-Observability/tracing"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
-	"log"
-	"net"
 	"time"
 )
 
-const (
-	serverURL      = "http://34.86.236.100/"
-	influxDBToken  = "I_UycfPULIG3VFr6eT-b0EzSIESMVb6rxZlS3n49zwHAcmpjPXQPS4u0eaZNY69hsWIVErE--T3lodcHQyx5rA=="
-	influxDBOrg    = "API-Observability"
-	influxDBBucket = "combined_metrics"
-)
-
 func MetricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	start := time.Now()
 	resp, err := handler(ctx, req)
@@ -36,16 +28,7 @@ func MetricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnarySe
 	statusCode := status.Code(err).String()
 
 	// Extract peer information
-	p, ok := peer.FromContext(ctx)
-	ipAddress := ""
-	if ok && p.Addr != net.Addr(nil) {
-		host, _, err := net.SplitHostPort(p.Addr.String())
-		if err == nil {
-			ipAddress = host
-		} else {
-			log.Fatalf("Error while parsing peer address: %v", err)
-		}
-	}
+	ipAddress := clientAddress(ctx)
 
 	// Increment request count
 	requestCount := 1
@@ -67,36 +50,33 @@ func MetricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnarySe
 	}
 
 	// Record metrics to InfluxDB (or print to console, log, etc.)
-	writeMetrics(duration, err, reqSize, respSize, methodName, statusCode, requestCount, errorRate, ipAddress, userAgent)
+	writeMetrics(ctx, duration, err, reqSize, respSize, methodName, statusCode, requestCount, errorRate, ipAddress, userAgent)
 	return resp, err
 }
 
-func writeMetrics(duration time.Duration, err error, reqSize, respSize int, methodName, statusCode string, requestCount, errorRate int, ipAddress, userAgent string) {
-	// Create a new InfluxDB client
-	client := influxdb2.NewClient(serverURL, influxDBToken)
-	defer client.Close()
-
-	// Create a write API (this can be reused)
-	writeAPI := client.WriteAPI(influxDBOrg, influxDBBucket)
+func writeMetrics(ctx context.Context, duration time.Duration, err error, reqSize, respSize int, methodName, statusCode string, requestCount, errorRate int, ipAddress, userAgent string) {
+	fields := map[string]interface{}{
+		"error":         err != nil,
+		"request_size":  reqSize,
+		"response_size": respSize,
+		"request_count": requestCount,
+		"error_rate":    errorRate,
+		"request_id":    requestid.FromContext(ctx),
+	}
+	// Link the point to its trace so a slow call can be looked up
+	for k, v := range tracing.ExemplarFields(ctx) {
+		fields[k] = v
+	}
 
-	// Create a point to write (measurement name is "gRPCMetrics")
-	point := influxdb2.NewPoint(
-		"gRPCMetrics",
-		map[string]string{"endpoint": methodName, "ip_address": ipAddress, "user_agent": userAgent},
-		map[string]interface{}{
-			"duration":      duration.Seconds(),
-			"error":         err != nil,
-			"request_size":  reqSize,
-			"response_size": respSize,
-			"request_count": requestCount,
-			"error_rate":    errorRate,
+	metrics.Write(
+		duration,
+		map[string]string{
+			"endpoint":    methodName,
+			"status_code": statusCode,
+			"side":        metrics.SideServer,
+			"ip_address":  ipAddress,
+			"user_agent":  userAgent,
 		},
-		time.Now(),
+		fields,
 	)
-
-	// Write the point
-	writeAPI.WritePoint(point)
-
-	// Ensure data is written
-	writeAPI.Flush()
 }
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Ling-Qingran/gRPC-Observability/internal/env"
+)
+
+// WithEnv returns o with the METRICS_* environment variables that are set
+// applied. A variable that is set but cannot be parsed is an error. The
+// server reads them as part of its configuration; programs using the
+// instrumented clients get them at startup.
+func (o Options) WithEnv() (Options, error) {
+	e := env.Reader{}
+
+	tags := &o.Tags
+	e.String("METRICS_USER_AGENT_TAG", &tags.UserAgentMode)
+	e.String("METRICS_IP_TAG", &tags.IPMode)
+	e.Int("METRICS_IP_PREFIX_V4", &tags.IPv4Prefix)
+	e.Int("METRICS_IP_PREFIX_V6", &tags.IPv6Prefix)
+	e.String("METRICS_IP_HASH_SALT", &tags.IPHashSalt)
+	e.Int("METRICS_MAX_TAG_VALUES", &tags.MaxValues)
+	for tag, name := range map[string]string{
+		"endpoint":   "METRICS_ALLOW_ENDPOINT",
+		"ip_address": "METRICS_ALLOW_IP_ADDRESS",
+		"user_agent": "METRICS_ALLOW_USER_AGENT",
+	} {
+		if v, ok := e.Lookup(name); ok {
+			// Copied so the caller's map is left alone
+			allowlist := map[string][]string{}
+			for k, values := range tags.Allowlist {
+				allowlist[k] = values
+			}
+			allowlist[tag] = env.SplitList(v)
+			tags.Allowlist = allowlist
+		}
+	}
+
+	sampling := &o.Sampling
+	e.Float("METRICS_SAMPLE_RATE", &sampling.Rate)
+	methodRates(&e, "METRICS_SAMPLE_RATES", &sampling.MethodRates)
+	e.Bool("METRICS_SAMPLE_KEEP_ERRORS", &sampling.KeepErrors)
+	e.Duration("METRICS_SAMPLE_SLOW_THRESHOLD", &sampling.SlowThreshold)
+	e.Int("METRICS_MAX_POINTS_PER_SECOND", &sampling.MaxPointsPerSecond)
+	e.Duration("METRICS_SUMMARY_INTERVAL", &o.SummaryInterval)
+	e.Bool("METRICS_PER_REQUEST_POINTS", &o.PerRequestPoints)
+
+	return o, e.Err
+}
+
+// methodRates parses "method=rate,method=rate".
+func methodRates(e *env.Reader, name string, dst *map[string]float64) {
+	v, ok := e.Lookup(name)
+	if !ok {
+		return
+	}
+	rates := map[string]float64{}
+	for _, pair := range env.SplitList(v) {
+		method, rate, ok := strings.Cut(pair, "=")
+		if !ok {
+			e.Fail(name, v, fmt.Errorf("%q is not method=rate", pair))
+			return
+		}
+		f, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			e.Fail(name, v, err)
+			return
+		}
+		rates[method] = f
+	}
+	*dst = rates
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+)
+
+// histogramScale is the number of buckets per power of two. Eight buckets
+// bound the relative error of a reported quantile to about 9%.
+const histogramScale = 8
+
+// histogram is an exponential histogram of latencies in seconds. Buckets are
+// allocated sparsely, so memory grows with the spread of observed values
+// rather than with the number of observations.
+type histogram struct {
+	buckets map[int]uint64
+	zeros   uint64
+	count   uint64
+	sum     float64
+	min     float64
+	max     float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{buckets: map[int]uint64{}}
+}
+
+func (h *histogram) observe(v float64) {
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += v
+
+	if v <= 0 {
+		h.zeros++
+		return
+	}
+	h.buckets[int(math.Ceil(math.Log2(v)*histogramScale))]++
+}
+
+// quantile returns the upper bound of the bucket holding the q-th quantile,
+// clamped to the observed range.
+func (h *histogram) quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	if rank <= h.zeros {
+		return h.min
+	}
+
+	indexes := make([]int, 0, len(h.buckets))
+	for i := range h.buckets {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	cumulative := h.zeros
+	for _, i := range indexes {
+		cumulative += h.buckets[i]
+		if cumulative >= rank {
+			return math.Max(h.min, math.Min(h.max, math.Exp2(float64(i)/histogramScale)))
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		values []float64
+		q      float64
+		want   float64
+	}{
+		{"empty", nil, 0.5, 0},
+		{"single sample p0", []float64{3}, 0, 3},
+		{"single sample p50 clamped to max", []float64{3}, 0.5, 3},
+		{"single sample p100", []float64{3}, 1, 3},
+		{"p0 is min", []float64{0.5, 1, 2}, 0, 0.5},
+		{"p100 is max", []float64{0.5, 1, 2}, 1, 2},
+		{"bucket upper bound", []float64{1, 3, 100}, 0.5, math.Exp2(13.0 / histogramScale)},
+		{"rank in zeros", []float64{0, 0, 1, 2}, 0.5, 0},
+		{"rank past zeros", []float64{0, 0, 1, 2}, 0.75, 1},
+		{"all zeros", []float64{0, 0, 0}, 0.99, 0},
+		{"upper bound clamped to max", []float64{1, 1.5}, 1, 1.5},
+		{"p99 of many", append(repeat(0.01, 99), 5), 0.99, upperBound(0.01)},
+		{"p99 reaches outlier", append(repeat(0.01, 98), 5, 5), 0.99, 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newHistogram()
+			for _, v := range tc.values {
+				h.observe(v)
+			}
+			got := h.quantile(tc.q)
+			if math.Abs(got-tc.want) > 1e-9*math.Max(1, tc.want) {
+				t.Errorf("quantile(%v) = %v, want %v", tc.q, got, tc.want)
+			}
+			if len(tc.values) > 0 && (got < h.min || got > h.max) {
+				t.Errorf("quantile(%v) = %v outside [%v, %v]", tc.q, got, h.min, h.max)
+			}
+		})
+	}
+}
+
+// TestHistogramRelativeError checks quantiles stay within the bucket width
+// of the exact value.
+func TestHistogramRelativeError(t *testing.T) {
+	h := newHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.observe(float64(i) / 1000)
+	}
+	maxError := math.Exp2(1.0/histogramScale) - 1
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		got := h.quantile(q)
+		if rel := (got - q) / q; rel < 0 || rel > maxError {
+			t.Errorf("quantile(%v) = %v, relative error %v outside [0, %v]", q, got, rel, maxError)
+		}
+	}
+}
+
+// upperBound is the upper bound of the bucket holding v.
+func upperBound(v float64) float64 {
+	return math.Exp2(math.Ceil(math.Log2(v)*histogramScale) / histogramScale)
+}
+
+func repeat(v float64, n int) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = v
+	}
+	return values
+}
@@ -0,0 +1,82 @@
+// Package metrics writes RPC observations to InfluxDB. It is shared by the
+// server interceptor and the instrumented clients so that both sides of a call
+// land in the same measurement with the same tag policy.
+package metrics
+
+import (
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Measurement is the InfluxDB measurement RPC observations are written to.
+const Measurement = "gRPCMetrics"
+
+// BackendMeasurement holds one point per call made to a storage backend while
+// serving an RPC.
+const BackendMeasurement = "gRPCBackendMetrics"
+
+// SpanMeasurement holds finished trace spans, one point per span.
+const SpanMeasurement = "gRPCSpans"
+
+// ReloadMeasurement holds one point per configuration reload attempt.
+const ReloadMeasurement = "configReloads"
+
+// Values of the "side" tag, telling server and client observations of the
+// same method apart.
+const (
+	SideServer = "server"
+	SideClient = "client"
+)
+
+// Write records one call that took duration. Tags are bounded by the tag
+// policy first, and the number of collapsed values is added as the
+// "tags_collapsed" field. The latency also feeds the periodic summaries in
+// SummaryMeasurement, which see every call. The per-call point itself is
+// sampled, with request_count and error_rate scaled by the sample weight, and
+// skipped entirely when Options.PerRequestPoints is false.
+func Write(duration time.Duration, tags map[string]string, fields map[string]interface{}) {
+	p := active.Load()
+	weight, keep := p.sampling.sample(tags["endpoint"], duration, fields["error"] == true)
+
+	// Bound tag cardinality before the values reach InfluxDB
+	fields["tags_collapsed"] = p.tags.Apply(tags)
+	fields["duration"] = duration.Seconds()
+
+	summaries.observe(tags, duration)
+	if p.perRequestPoints && keep {
+		scale(fields, weight)
+		writePoints(influxdb2.NewPoint(Measurement, tags, fields, time.Now()))
+	}
+}
+
+// WriteBackend records one backend call that took duration. The "endpoint"
+// tag should name the gRPC method the call was made for, so backend time can
+// be compared with the method's duration in Measurement.
+func WriteBackend(duration time.Duration, tags map[string]string, fields map[string]interface{}) {
+	fields["tags_collapsed"] = active.Load().tags.Apply(tags)
+	fields["duration"] = duration.Seconds()
+	writePoints(influxdb2.NewPoint(BackendMeasurement, tags, fields, time.Now()))
+}
+
+// WriteSpan records one finished span that started at start. Trace and span
+// IDs belong in fields: as tags they would create a series per trace.
+func WriteSpan(start time.Time, duration time.Duration, tags map[string]string, fields map[string]interface{}) {
+	fields["duration"] = duration.Seconds()
+	writePoints(influxdb2.NewPoint(SpanMeasurement, tags, fields, start))
+}
+
+// WriteReload records one attempt to reload the configuration. It bypasses
+// the tag policy and sampling: reloads are rare and every one matters.
+func WriteReload(tags map[string]string, fields map[string]interface{}) {
+	writePoints(influxdb2.NewPoint(ReloadMeasurement, tags, fields, time.Now()))
+}
+
+// writePoints queues points for the current sink and returns without waiting
+// for them to be written; Flush waits.
+func writePoints(points ...*write.Point) {
+	if w := currentWriter(); w != nil {
+		w.enqueue(points...)
+	}
+}
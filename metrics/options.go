@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// Options are the metrics settings that can be changed while running.
+type Options struct {
+	Tags     TagOptions      `yaml:"tags"`
+	Sampling SamplingOptions `yaml:"sampling"`
+	// SummaryInterval is the latency summary window. Zero disables summaries.
+	SummaryInterval time.Duration `yaml:"summary_interval"`
+	// PerRequestPoints controls whether Write emits one point per call.
+	// Turning it off leaves only the summaries in InfluxDB.
+	PerRequestPoints bool `yaml:"per_request_points"`
+}
+
+// DefaultOptions returns the settings used until Configure is called.
+func DefaultOptions() Options {
+	return Options{
+		Tags:             DefaultTagOptions(),
+		Sampling:         DefaultSamplingOptions(),
+		SummaryInterval:  time.Minute,
+		PerRequestPoints: true,
+	}
+}
+
+// Validate checks every section of o.
+func (o Options) Validate() error {
+	if err := o.Tags.Validate(); err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+	if err := o.Sampling.Validate(); err != nil {
+		return fmt.Errorf("sampling: %w", err)
+	}
+	if o.SummaryInterval < 0 {
+		return fmt.Errorf("summary interval %v is negative", o.SummaryInterval)
+	}
+	return nil
+}
+
+// pipeline is the active configuration. It is replaced as a whole so that a
+// call never sees the tag policy of one configuration and the sampling of
+// another.
+type pipeline struct {
+	tags             *TagPolicy
+	sampling         *sampler
+	perRequestPoints bool
+}
+
+var active atomic.Pointer[pipeline]
+
+// init applies the METRICS_* environment variables, so programs that never
+// call Configure, such as those using the instrumented clients, honour them.
+func init() {
+	o, err := DefaultOptions().WithEnv()
+	if err == nil {
+		err = Configure(o)
+	}
+	if err != nil {
+		log.Printf("Ignoring metrics environment: %v", err)
+		Configure(DefaultOptions())
+	}
+}
+
+// Configure applies o to all subsequent writes. The tag values seen and the
+// sampling budget carry over unless their settings changed, so reloading
+// an unchanged configuration cannot be used to lift MaxValues or the
+// MaxPointsPerSecond backoff.
+func Configure(o Options) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	next := &pipeline{perRequestPoints: o.PerRequestPoints}
+	prev := active.Load()
+	if prev != nil && reflect.DeepEqual(prev.tags.TagOptions, o.Tags) {
+		next.tags = prev.tags
+	} else {
+		next.tags = NewTagPolicy(o.Tags)
+	}
+	if prev != nil && reflect.DeepEqual(prev.sampling.SamplingOptions, o.Sampling) {
+		next.sampling = prev.sampling
+	} else {
+		next.sampling = newSampler(o.Sampling)
+	}
+	active.Store(next)
+	summaries.setInterval(o.SummaryInterval)
+	return nil
+}
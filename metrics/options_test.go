@@ -0,0 +1,48 @@
+package metrics
+
+import "testing"
+
+func TestConfigureKeepsStateUnlessSettingsChange(t *testing.T) {
+	t.Cleanup(func() { Configure(DefaultOptions()) })
+
+	o := DefaultOptions()
+	o.Tags.MaxValues = 1
+	if err := Configure(o); err != nil {
+		t.Fatal(err)
+	}
+	apply := func(value string) string {
+		tags := map[string]string{"endpoint": value}
+		active.Load().tags.Apply(tags)
+		return tags["endpoint"]
+	}
+	apply("/UserService/GetUser")
+
+	// Reloading the same settings keeps the values seen, so the limit holds
+	if err := Configure(o); err != nil {
+		t.Fatal(err)
+	}
+	if got := apply("/UserService/CreateUser"); got != otherTagValue {
+		t.Errorf("after reloading unchanged settings, new value = %q, want %q", got, otherTagValue)
+	}
+	sampling := active.Load().sampling
+
+	// Changing the tag settings starts over; the sampler is untouched
+	o.Tags.MaxValues = 2
+	if err := Configure(o); err != nil {
+		t.Fatal(err)
+	}
+	if got := apply("/UserService/CreateUser"); got != "/UserService/CreateUser" {
+		t.Errorf("after changing max_values, new value = %q, want it kept", got)
+	}
+	if active.Load().sampling != sampling {
+		t.Error("sampler replaced although sampling settings did not change")
+	}
+
+	o.Sampling.Rate = 0.5
+	if err := Configure(o); err != nil {
+		t.Fatal(err)
+	}
+	if active.Load().sampling == sampling {
+		t.Error("sampler kept although the sampling rate changed")
+	}
+}
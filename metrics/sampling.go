@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SamplingOptions configures which calls get a per-request point.
+type SamplingOptions struct {
+	// Rate is the fraction of calls kept for methods without their own rate.
+	Rate float64 `yaml:"rate"`
+	// MethodRates overrides Rate per full method name.
+	MethodRates map[string]float64 `yaml:"method_rates"`
+	// KeepErrors keeps every failed call, with weight 1.
+	KeepErrors bool `yaml:"keep_errors"`
+	// SlowThreshold keeps every call at least this slow, with weight 1.
+	// Zero disables it.
+	SlowThreshold time.Duration `yaml:"slow_threshold"`
+	// MaxPointsPerSecond is a budget for sampled points. When it is exceeded
+	// the sampling interval doubles each second until the rate fits, then
+	// relaxes again. Zero disables the budget.
+	MaxPointsPerSecond int `yaml:"max_points_per_second"`
+}
+
+// DefaultSamplingOptions keeps every call.
+func DefaultSamplingOptions() SamplingOptions {
+	return SamplingOptions{Rate: 1, KeepErrors: true, SlowThreshold: time.Second}
+}
+
+// Validate checks that rates are fractions and limits are not negative.
+func (o SamplingOptions) Validate() error {
+	if o.Rate < 0 || o.Rate > 1 {
+		return fmt.Errorf("sample rate %v is not between 0 and 1", o.Rate)
+	}
+	for method, rate := range o.MethodRates {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("sample rate %v for %s is not between 0 and 1", rate, method)
+		}
+	}
+	if o.SlowThreshold < 0 {
+		return fmt.Errorf("slow threshold %v is negative", o.SlowThreshold)
+	}
+	if o.MaxPointsPerSecond < 0 {
+		return fmt.Errorf("max points per second %d is negative", o.MaxPointsPerSecond)
+	}
+	return nil
+}
+
+// sampler decides which calls get a per-request point. Rates are turned into
+// "keep 1 in N" and every kept point carries N as its weight, so summing
+// request_count and error_rate downstream still counts every call while the
+// fields stay integers.
+type sampler struct {
+	SamplingOptions
+
+	mu          sync.Mutex
+	factor      int
+	windowStart time.Time
+	windowCount int
+}
+
+func newSampler(o SamplingOptions) *sampler {
+	return &sampler{SamplingOptions: o, factor: 1}
+}
+
+// sample reports whether to write a point for a call to method and the weight
+// the point stands for.
+func (s *sampler) sample(method string, duration time.Duration, failed bool) (int, bool) {
+	if failed && s.KeepErrors {
+		return 1, true
+	}
+	if s.SlowThreshold > 0 && duration >= s.SlowThreshold {
+		return 1, true
+	}
+
+	rate, ok := s.MethodRates[method]
+	if !ok {
+		rate = s.Rate
+	}
+	if rate <= 0 {
+		return 0, false
+	}
+	interval := 1
+	if rate < 1 {
+		interval = int(math.Round(1 / rate))
+	}
+	interval *= s.backoff()
+
+	if interval > 1 && rand.Intn(interval) != 0 {
+		return 0, false
+	}
+	s.kept()
+	return interval, true
+}
+
+// backoff returns the factor the sampling interval is multiplied by, rolling
+// the one-second budget window when it has elapsed.
+func (s *sampler) backoff() int {
+	if s.MaxPointsPerSecond <= 0 {
+		return 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := time.Now(); now.Sub(s.windowStart) >= time.Second {
+		switch {
+		case s.windowCount > s.MaxPointsPerSecond:
+			s.factor *= 2
+		case s.windowCount < s.MaxPointsPerSecond/2 && s.factor > 1:
+			s.factor /= 2
+		}
+		s.windowStart, s.windowCount = now, 0
+	}
+	return s.factor
+}
+
+// kept counts a sampled point against the current budget window.
+func (s *sampler) kept() {
+	if s.MaxPointsPerSecond <= 0 {
+		return
+	}
+	s.mu.Lock()
+	s.windowCount++
+	s.mu.Unlock()
+}
+
+// scale multiplies the count fields of a sampled point by its weight.
+func scale(fields map[string]interface{}, weight int) {
+	for _, key := range []string{"request_count", "error_rate", "retries"} {
+		if n, ok := fields[key].(int); ok {
+			fields[key] = n * weight
+		}
+	}
+	fields["sample_weight"] = weight
+}
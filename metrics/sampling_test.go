@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// weightedCount samples n calls and returns how many points were kept and
+// the sum of their weights.
+func weightedCount(s *sampler, method string, n int, duration time.Duration, failed bool) (kept, total int) {
+	for i := 0; i < n; i++ {
+		if weight, ok := s.sample(method, duration, failed); ok {
+			kept++
+			total += weight
+		}
+	}
+	return kept, total
+}
+
+func TestSamplerWeightsAreUnbiased(t *testing.T) {
+	const calls = 200000
+	for _, rate := range []float64{1, 0.5, 0.3, 0.1, 0.01} {
+		o := DefaultSamplingOptions()
+		o.Rate = rate
+		kept, total := weightedCount(newSampler(o), "/UserService/GetUser", calls, time.Millisecond, false)
+
+		// 1 in N sampling keeps calls/N points of weight N, a binomial
+		// count; allow five standard deviations
+		n := math.Round(1 / rate)
+		sd := n * math.Sqrt(calls/n*(1-1/n))
+		if math.Abs(float64(total-calls)) > 5*sd+1 {
+			t.Errorf("rate %v: weights sum to %d for %d calls (%d points)", rate, total, calls, kept)
+		}
+		if rate < 1 && kept >= calls/2+calls/100 {
+			t.Errorf("rate %v: kept %d of %d points", rate, kept, calls)
+		}
+	}
+}
+
+func TestSamplerMethodRates(t *testing.T) {
+	o := DefaultSamplingOptions()
+	o.Rate = 0
+	o.MethodRates = map[string]float64{"/UserService/DeleteUser": 1}
+	s := newSampler(o)
+	if _, ok := s.sample("/UserService/GetUser", time.Millisecond, false); ok {
+		t.Error("call kept at rate 0")
+	}
+	if weight, ok := s.sample("/UserService/DeleteUser", time.Millisecond, false); !ok || weight != 1 {
+		t.Errorf("method rate 1: sample = %d, %v, want 1, true", weight, ok)
+	}
+}
+
+func TestSamplerKeepsErrorsAndSlowCalls(t *testing.T) {
+	o := DefaultSamplingOptions()
+	o.Rate = 0.001
+	o.SlowThreshold = 100 * time.Millisecond
+	o.MaxPointsPerSecond = 1
+	s := newSampler(o)
+	// Exhaust the budget so it is backing off too
+	s.factor = 64
+
+	for _, tc := range []struct {
+		name     string
+		duration time.Duration
+		failed   bool
+	}{
+		{"error", time.Millisecond, true},
+		{"slow", time.Second, false},
+		{"at threshold", 100 * time.Millisecond, false},
+	} {
+		kept, total := weightedCount(s, "/UserService/GetUser", 1000, tc.duration, tc.failed)
+		if kept != 1000 || total != 1000 {
+			t.Errorf("%s: kept %d with total weight %d of 1000, want every call with weight 1", tc.name, kept, total)
+		}
+	}
+
+	o.KeepErrors, o.Rate = false, 0
+	if _, ok := newSampler(o).sample("/UserService/GetUser", time.Millisecond, true); ok {
+		t.Error("error kept with KeepErrors off and rate 0")
+	}
+}
+
+func TestSamplerBudgetBackoff(t *testing.T) {
+	o := DefaultSamplingOptions()
+	o.MaxPointsPerSecond = 10
+	s := newSampler(o)
+
+	// The first call opens a window; going over budget in it doubles the
+	// interval once the window ends
+	weightedCount(s, "m", 50, time.Millisecond, false)
+	s.windowStart = s.windowStart.Add(-time.Second)
+	if weight, _ := s.sample("m", time.Millisecond, false); s.factor != 2 {
+		t.Fatalf("factor after an overrun = %d (weight %d), want 2", s.factor, weight)
+	}
+
+	// Kept points stand for the calls skipped by the backoff
+	const calls = 100000
+	_, total := weightedCount(s, "m", calls, time.Millisecond, false)
+	if math.Abs(float64(total-calls)) > 5*2*math.Sqrt(calls/2*0.5)+1 {
+		t.Errorf("weights sum to %d for %d calls while backing off", total, calls)
+	}
+
+	// A quiet window relaxes the interval again
+	s.windowCount = 0
+	s.windowStart = s.windowStart.Add(-time.Second)
+	s.sample("m", time.Millisecond, false)
+	if s.factor != 1 {
+		t.Errorf("factor after a quiet window = %d, want 1", s.factor)
+	}
+}
+
+func TestScale(t *testing.T) {
+	fields := map[string]interface{}{"request_count": 1, "error_rate": 1, "retries": 2, "duration": 0.5}
+	scale(fields, 10)
+	for k, want := range map[string]interface{}{"request_count": 10, "error_rate": 10, "retries": 20, "duration": 0.5, "sample_weight": 10} {
+		if fields[k] != want {
+			t.Errorf("%s = %v, want %v", k, fields[k], want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// Sink is the InfluxDB v2 bucket points are written to. The zero Sink is
+// disabled: points are dropped instead of written.
+type Sink struct {
+	URL    string `json:"url" yaml:"url"`
+	Org    string `json:"org" yaml:"org"`
+	Bucket string `json:"bucket" yaml:"bucket"`
+	Token  string `json:"token" yaml:"token"`
+	// TokenFile is read for the token when Token is empty, e.g. a mounted
+	// Kubernetes or Cloud Run secret.
+	TokenFile string `json:"token_file" yaml:"token_file"`
+}
+
+// Enabled reports whether s names a destination.
+func (s Sink) Enabled() bool {
+	return s.URL != ""
+}
+
+// Validate checks that an enabled sink is complete.
+func (s Sink) Validate() error {
+	if !s.Enabled() {
+		return nil
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid InfluxDB URL %q", s.URL)
+	}
+	var missing []string
+	if s.Org == "" {
+		missing = append(missing, "org")
+	}
+	if s.Bucket == "" {
+		missing = append(missing, "bucket")
+	}
+	if s.Token == "" {
+		missing = append(missing, "token")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("InfluxDB sink %s is missing %s", s.URL, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// String describes s with the token masked, so a sink can be logged safely.
+func (s Sink) String() string {
+	if !s.Enabled() {
+		return "disabled"
+	}
+	token := "<unset>"
+	if s.Token != "" {
+		token = "****"
+	}
+	return fmt.Sprintf("%s org=%s bucket=%s token=%s", s.URL, s.Org, s.Bucket, token)
+}
+
+// GoString masks the token for %#v as well.
+func (s Sink) GoString() string {
+	return "metrics.Sink{" + s.String() + "}"
+}
+
+// LoadSink reads the sink configuration. Settings come from the JSON file
+// named by INFLUXDB_CONFIG, overridden field by field by INFLUXDB_URL,
+// INFLUXDB_ORG, INFLUXDB_BUCKET and INFLUXDB_TOKEN. When no token is given
+// directly it is read from INFLUXDB_TOKEN_FILE or the file's token_file.
+//
+// A sink with no URL is disabled and not an error; a sink with a URL must be
+// complete.
+func LoadSink() (Sink, error) {
+	var s Sink
+	if path := os.Getenv("INFLUXDB_CONFIG"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return Sink{}, fmt.Errorf("reading InfluxDB config: %w", err)
+		}
+		if err := json.Unmarshal(b, &s); err != nil {
+			return Sink{}, fmt.Errorf("parsing InfluxDB config %s: %w", path, err)
+		}
+	}
+
+	s, err := s.WithEnv().ResolveToken()
+	if err != nil {
+		return Sink{}, err
+	}
+	return s, s.Validate()
+}
+
+// WithEnv returns s with every field set in INFLUXDB_URL, INFLUXDB_ORG,
+// INFLUXDB_BUCKET, INFLUXDB_TOKEN or INFLUXDB_TOKEN_FILE overridden. A token
+// file from the environment also replaces a token set directly in s, unless
+// INFLUXDB_TOKEN is set too.
+func (s Sink) WithEnv() Sink {
+	if os.Getenv("INFLUXDB_TOKEN_FILE") != "" {
+		s.Token = ""
+	}
+	for env, field := range map[string]*string{
+		"INFLUXDB_URL":        &s.URL,
+		"INFLUXDB_ORG":        &s.Org,
+		"INFLUXDB_BUCKET":     &s.Bucket,
+		"INFLUXDB_TOKEN":      &s.Token,
+		"INFLUXDB_TOKEN_FILE": &s.TokenFile,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+		}
+	}
+	return s
+}
+
+// ResolveToken returns s with Token read from TokenFile when it is not set
+// directly.
+func (s Sink) ResolveToken() (Sink, error) {
+	if s.Token == "" && s.TokenFile != "" {
+		b, err := os.ReadFile(s.TokenFile)
+		if err != nil {
+			return Sink{}, fmt.Errorf("reading InfluxDB token file: %w", err)
+		}
+		s.Token = strings.TrimSpace(string(b))
+	}
+	return s, nil
+}
+
+// ErrSinkDisabled is returned by Ping when no sink is configured.
+var ErrSinkDisabled = errors.New("metrics sink disabled")
+
+// Ping checks that the InfluxDB server behind the current sink is reachable.
+// Like InfluxDB's /ping it does not check the token.
+func Ping(ctx context.Context) error {
+	s := currentSink()
+	if !s.Enabled() {
+		return ErrSinkDisabled
+	}
+	client := influxdb2.NewClient(s.URL, s.Token)
+	defer client.Close()
+	if _, err := client.Ping(ctx); err != nil {
+		return fmt.Errorf("pinging InfluxDB at %s: %w", s.URL, err)
+	}
+	return nil
+}
+
+var (
+	sinkMu sync.RWMutex
+	sink   *Sink
+	out    *writer
+)
+
+// SetSink redirects all subsequent writes to s. Passing the zero Sink
+// disables writing. Points queued for the previous sink are written to it
+// first.
+func SetSink(s Sink) {
+	sinkMu.Lock()
+	old := out
+	sink, out = &s, nil
+	if s.Enabled() {
+		out = newWriter(s)
+	}
+	sinkMu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+}
+
+// currentSink returns the configured sink, loading it from the environment on
+// first use so that clients get metrics without explicit setup. An invalid
+// configuration disables the sink rather than failing the caller.
+func currentSink() Sink {
+	sinkMu.RLock()
+	s := sink
+	sinkMu.RUnlock()
+	if s != nil {
+		return *s
+	}
+
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+	if sink == nil {
+		loaded, err := LoadSink()
+		if err != nil {
+			log.Printf("Metrics disabled: %v", err)
+			loaded = Sink{}
+		}
+		sink = &loaded
+		if loaded.Enabled() {
+			out = newWriter(loaded)
+		}
+	}
+	return *sink
+}
+
+// currentWriter returns the writer for the current sink, or nil when the
+// sink is disabled.
+func currentWriter() *writer {
+	currentSink()
+	sinkMu.RLock()
+	defer sinkMu.RUnlock()
+	return out
+}
+
+// flushWriter waits for the points queued so far to be written.
+func flushWriter() {
+	if w := currentWriter(); w != nil {
+		w.Flush()
+	}
+}
@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// SummaryMeasurement holds the periodic latency summaries, one point per
+// side, endpoint and status code for each window.
+const SummaryMeasurement = "gRPCLatencySummary"
+
+// summaryKey identifies the series a latency observation is aggregated into.
+type summaryKey struct {
+	side       string
+	endpoint   string
+	statusCode string
+}
+
+// summarizer aggregates latencies into histograms over fixed windows and
+// writes a summary point per series at the end of each window. The windows
+// tumble rather than roll: each call is in exactly one summary, so counts
+// can be summed across points, and a rolling view over several windows is
+// left to the query (p99 of the last 5m is the max of the last five 1m p99s
+// at worst, or can be recomputed from per-request points).
+type summarizer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	window   map[summaryKey]*histogram
+	start    time.Time
+	once     sync.Once
+	changed  chan struct{}
+}
+
+var summaries = &summarizer{interval: time.Minute, changed: make(chan struct{}, 1)}
+
+// setInterval changes the window length. Zero stops aggregation. Setting
+// the current length leaves the window running rather than restarting it.
+func (s *summarizer) setInterval(d time.Duration) {
+	s.mu.Lock()
+	changed := s.interval != d
+	s.interval = d
+	s.mu.Unlock()
+	if !changed {
+		return
+	}
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// observe adds a latency to the current window. Tags must already have been
+// bounded by the tag policy.
+func (s *summarizer) observe(tags map[string]string, duration time.Duration) {
+	key := summaryKey{side: tags["side"], endpoint: tags["endpoint"], statusCode: tags["status_code"]}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.interval <= 0 {
+		return
+	}
+	s.once.Do(func() { go s.run() })
+	if s.window == nil {
+		s.window = map[summaryKey]*histogram{}
+		s.start = time.Now()
+	}
+	h := s.window[key]
+	if h == nil {
+		h = newHistogram()
+		s.window[key] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+func (s *summarizer) run() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		interval := s.interval
+		s.mu.Unlock()
+		if interval > 0 {
+			ticker.Reset(interval)
+		} else {
+			ticker.Stop()
+		}
+
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.changed:
+		}
+	}
+}
+
+// Flush writes the latency summaries collected so far without waiting for the
+// end of the window, then waits for every queued point to reach InfluxDB.
+// Points are written in the background, so call it before exiting or the
+// last ones are lost.
+func Flush() {
+	summaries.flush()
+	flushWriter()
+}
+
+// flush swaps out the current window and writes its summaries.
+func (s *summarizer) flush() {
+	s.mu.Lock()
+	window, start := s.window, s.start
+	s.window = nil
+	s.mu.Unlock()
+
+	if len(window) == 0 {
+		return
+	}
+
+	end := time.Now()
+	points := make([]*write.Point, 0, len(window))
+	for key, h := range window {
+		points = append(points, influxdb2.NewPoint(
+			SummaryMeasurement,
+			map[string]string{"side": key.side, "endpoint": key.endpoint, "status_code": key.statusCode},
+			map[string]interface{}{
+				"count":          int64(h.count),
+				"p50":            h.quantile(0.50),
+				"p90":            h.quantile(0.90),
+				"p99":            h.quantile(0.99),
+				"min":            h.min,
+				"max":            h.max,
+				"mean":           h.sum / float64(h.count),
+				"window_seconds": end.Sub(start).Seconds(),
+			},
+			end,
+		))
+	}
+	writePoints(points...)
+}
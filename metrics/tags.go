@@ -0,0 +1,223 @@
+package metrics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// otherTagValue replaces tag values that are not allowed or that arrive after
+// a tag has reached its distinct value limit.
+const otherTagValue = "other"
+
+// User agent tag modes.
+const (
+	userAgentRaw     = "raw"     // keep the header as sent
+	userAgentFamily  = "family"  // "grpc-go"
+	userAgentVersion = "version" // "grpc-go/1.59"
+	userAgentDrop    = "drop"    // omit the tag
+)
+
+// IP address tag modes.
+const (
+	ipRaw    = "raw"    // keep the address as resolved
+	ipSubnet = "subnet" // "10.1.2.0/24"
+	ipHash   = "hash"   // salted, truncated SHA-256
+	ipDrop   = "drop"   // omit the tag
+)
+
+// TagOptions configures a TagPolicy.
+type TagOptions struct {
+	// UserAgentMode is one of "raw", "family", "version" or "drop".
+	UserAgentMode string `yaml:"user_agent"`
+	// IPMode is one of "raw", "subnet", "hash" or "drop".
+	IPMode     string `yaml:"ip_address"`
+	IPv4Prefix int    `yaml:"ipv4_prefix"`
+	IPv6Prefix int    `yaml:"ipv6_prefix"`
+	IPHashSalt string `yaml:"ip_hash_salt"`
+
+	// Allowlist maps a tag key to the only values it may take. Anything else
+	// is collapsed into "other".
+	Allowlist map[string][]string `yaml:"allowlist"`
+
+	// MaxValues is the number of distinct values a tag may take before new
+	// values are collapsed into "other". Zero means unlimited.
+	MaxValues int `yaml:"max_values"`
+}
+
+// DefaultTagOptions keeps client library versions and /24 (IPv4) or /48
+// (IPv6) networks, which is enough to tell clients apart without one series
+// per caller.
+func DefaultTagOptions() TagOptions {
+	return TagOptions{
+		UserAgentMode: userAgentVersion,
+		IPMode:        ipSubnet,
+		IPv4Prefix:    24,
+		IPv6Prefix:    48,
+		MaxValues:     1000,
+	}
+}
+
+// Validate checks the modes and prefix lengths.
+func (o TagOptions) Validate() error {
+	switch o.UserAgentMode {
+	case userAgentRaw, userAgentFamily, userAgentVersion, userAgentDrop:
+	default:
+		return fmt.Errorf("unknown user agent tag mode %q", o.UserAgentMode)
+	}
+	switch o.IPMode {
+	case ipRaw, ipSubnet, ipHash, ipDrop:
+	default:
+		return fmt.Errorf("unknown IP address tag mode %q", o.IPMode)
+	}
+	if o.IPv4Prefix < 0 || o.IPv4Prefix > 32 {
+		return fmt.Errorf("IPv4 prefix %d out of range", o.IPv4Prefix)
+	}
+	if o.IPv6Prefix < 0 || o.IPv6Prefix > 128 {
+		return fmt.Errorf("IPv6 prefix %d out of range", o.IPv6Prefix)
+	}
+	if o.MaxValues < 0 {
+		return fmt.Errorf("max tag values %d is negative", o.MaxValues)
+	}
+	return nil
+}
+
+// TagPolicy bounds the cardinality of the tags written with each metric point.
+type TagPolicy struct {
+	TagOptions
+	allowlist map[string]map[string]bool
+
+	mu   sync.Mutex
+	seen map[string]map[string]bool
+}
+
+// NewTagPolicy returns a policy applying o, with no values seen yet.
+func NewTagPolicy(o TagOptions) *TagPolicy {
+	p := &TagPolicy{TagOptions: o, allowlist: map[string]map[string]bool{}}
+	for tag, values := range o.Allowlist {
+		set := map[string]bool{}
+		for _, v := range values {
+			set[v] = true
+		}
+		p.allowlist[tag] = set
+	}
+	return p
+}
+
+// Apply rewrites tags in place according to the policy and returns the number
+// of values collapsed into "other" by the allowlist or MaxValues. Values
+// normalized by the user agent and IP modes are expected and not counted, so
+// a non-zero count means a tag is overflowing.
+func (p *TagPolicy) Apply(tags map[string]string) int {
+	collapsed := 0
+
+	if ua, ok := tags["user_agent"]; ok {
+		if v, keep := normalizeUserAgent(ua, p.UserAgentMode); keep {
+			tags["user_agent"] = v
+		} else {
+			delete(tags, "user_agent")
+		}
+	}
+
+	if ip, ok := tags["ip_address"]; ok {
+		if v, keep := p.bucketIP(ip); keep {
+			tags["ip_address"] = v
+		} else {
+			delete(tags, "ip_address")
+		}
+	}
+
+	for key, value := range tags {
+		if allowed, ok := p.allowlist[key]; ok && !allowed[value] {
+			tags[key] = otherTagValue
+			collapsed++
+			continue
+		}
+		if !p.admit(key, value) {
+			tags[key] = otherTagValue
+			collapsed++
+		}
+	}
+	return collapsed
+}
+
+// admit records value as seen for key and reports whether it fits within
+// MaxValues.
+func (p *TagPolicy) admit(key, value string) bool {
+	if p.MaxValues <= 0 || value == otherTagValue {
+		return true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.seen == nil {
+		p.seen = map[string]map[string]bool{}
+	}
+	values := p.seen[key]
+	if values == nil {
+		values = map[string]bool{}
+		p.seen[key] = values
+	}
+	if values[value] {
+		return true
+	}
+	if len(values) >= p.MaxValues {
+		return false
+	}
+	values[value] = true
+	return true
+}
+
+func (p *TagPolicy) bucketIP(addr string) (string, bool) {
+	switch p.IPMode {
+	case ipDrop:
+		return "", false
+	case ipSubnet:
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return addr, true
+		}
+		bits, prefix := 128, p.IPv6Prefix
+		if v4 := ip.To4(); v4 != nil {
+			ip, bits, prefix = v4, 32, p.IPv4Prefix
+		}
+		network := net.IPNet{IP: ip.Mask(net.CIDRMask(prefix, bits)), Mask: net.CIDRMask(prefix, bits)}
+		return network.String(), true
+	case ipHash:
+		if addr == "" {
+			return addr, true
+		}
+		sum := sha256.Sum256([]byte(p.IPHashSalt + addr))
+		return hex.EncodeToString(sum[:8]), true
+	}
+	return addr, true
+}
+
+// normalizeUserAgent reduces a user agent to its first product token, e.g.
+// "grpc-python/1.59.0 grpc-c/36.0.0 (linux; chttp2)" becomes "grpc-python"
+// or "grpc-python/1.59".
+func normalizeUserAgent(ua, mode string) (string, bool) {
+	switch mode {
+	case userAgentDrop:
+		return "", false
+	case userAgentFamily, userAgentVersion:
+	default:
+		return ua, true
+	}
+
+	product := strings.Fields(ua)
+	if len(product) == 0 {
+		return "", true
+	}
+	family, version, _ := strings.Cut(product[0], "/")
+	if mode == userAgentFamily || version == "" {
+		return family, true
+	}
+	if parts := strings.SplitN(version, ".", 3); len(parts) >= 2 {
+		version = parts[0] + "." + parts[1]
+	}
+	return family + "/" + version, true
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTagPolicyNormalizesWithoutCounting(t *testing.T) {
+	p := NewTagPolicy(DefaultTagOptions())
+	tags := map[string]string{
+		"endpoint":   "/UserService/GetUser",
+		"ip_address": "203.0.113.77",
+		"user_agent": "grpc-go/1.59.0",
+	}
+	if n := p.Apply(tags); n != 0 {
+		t.Errorf("Apply = %d collapsed, want 0 for normalized values", n)
+	}
+	want := map[string]string{
+		"endpoint":   "/UserService/GetUser",
+		"ip_address": "203.0.113.0/24",
+		"user_agent": "grpc-go/1.59",
+	}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %v, want %v", tags, want)
+	}
+}
+
+func TestTagPolicySubnet(t *testing.T) {
+	o := DefaultTagOptions()
+	o.IPv4Prefix, o.IPv6Prefix = 16, 32
+	p := NewTagPolicy(o)
+	for addr, want := range map[string]string{
+		"10.1.2.3":             "10.1.0.0/16",
+		"2001:db8:aa:bb::1":    "2001:db8::/32",
+		"::ffff:192.168.10.20": "192.168.0.0/16",
+		"bufconn":              "bufconn",
+	} {
+		tags := map[string]string{"ip_address": addr}
+		p.Apply(tags)
+		if tags["ip_address"] != want {
+			t.Errorf("ip_address %q = %q, want %q", addr, tags["ip_address"], want)
+		}
+	}
+}
+
+func TestTagPolicyAllowlist(t *testing.T) {
+	o := DefaultTagOptions()
+	o.Allowlist = map[string][]string{"endpoint": {"/UserService/GetUser"}}
+	p := NewTagPolicy(o)
+
+	tags := map[string]string{"endpoint": "/UserService/GetUser"}
+	if n := p.Apply(tags); n != 0 || tags["endpoint"] != "/UserService/GetUser" {
+		t.Errorf("allowed value: Apply = %d, endpoint = %q", n, tags["endpoint"])
+	}
+	tags = map[string]string{"endpoint": "/Scanner/Probe"}
+	if n := p.Apply(tags); n != 1 || tags["endpoint"] != otherTagValue {
+		t.Errorf("unlisted value: Apply = %d, endpoint = %q, want 1, %q", n, tags["endpoint"], otherTagValue)
+	}
+}
+
+func TestTagPolicyOverflow(t *testing.T) {
+	o := DefaultTagOptions()
+	o.MaxValues = 2
+	p := NewTagPolicy(o)
+
+	for i, tc := range []struct {
+		value, want string
+		collapsed   int
+	}{
+		{"a", "a", 0},
+		{"b", "b", 0},
+		{"c", otherTagValue, 1},
+		{"a", "a", 0}, // seen values stay admitted
+		{"d", otherTagValue, 1},
+	} {
+		tags := map[string]string{"endpoint": tc.value}
+		if n := p.Apply(tags); n != tc.collapsed || tags["endpoint"] != tc.want {
+			t.Errorf("call %d (%q): Apply = %d, endpoint = %q, want %d, %q", i, tc.value, n, tags["endpoint"], tc.collapsed, tc.want)
+		}
+	}
+}
+
+func TestTagPolicyDrop(t *testing.T) {
+	o := DefaultTagOptions()
+	o.UserAgentMode, o.IPMode = userAgentDrop, ipDrop
+	tags := map[string]string{"endpoint": "/UserService/GetUser", "ip_address": "10.0.0.1", "user_agent": "grpc-go/1.59.0"}
+	if n := NewTagPolicy(o).Apply(tags); n != 0 {
+		t.Errorf("Apply = %d, want 0", n)
+	}
+	if want := map[string]string{"endpoint": "/UserService/GetUser"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %v, want %v", tags, want)
+	}
+}
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+const (
+	// queueSize bounds the points waiting to be written. Beyond it points
+	// are dropped rather than holding up the caller.
+	queueSize = 10000
+	// batchSize and flushInterval control how points are grouped into
+	// writes to InfluxDB.
+	batchSize     = 500
+	flushInterval = time.Second
+	// writeTimeout bounds one write request to InfluxDB.
+	writeTimeout = 10 * time.Second
+	// dropLogInterval limits how often dropped points are logged.
+	dropLogInterval = time.Minute
+)
+
+// writer sends points to one sink in the background, through a single
+// InfluxDB client and batching WriteAPI. Callers only enqueue, so a slow or
+// unreachable InfluxDB never adds to the latency being measured.
+type writer struct {
+	sink   Sink
+	client influxdb2.Client
+	api    api.WriteAPI
+	queue  chan *write.Point
+	flush  chan chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+
+	dropped atomic.Int64
+	lastLog atomic.Int64
+}
+
+func newWriter(s Sink) *writer {
+	client := influxdb2.NewClientWithOptions(s.URL, s.Token, influxdb2.DefaultOptions().
+		SetBatchSize(batchSize).
+		SetFlushInterval(uint(flushInterval.Milliseconds())).
+		SetHTTPRequestTimeout(uint(writeTimeout.Seconds())))
+	w := &writer{
+		sink:   s,
+		client: client,
+		api:    client.WriteAPI(s.Org, s.Bucket),
+		queue:  make(chan *write.Point, queueSize),
+		flush:  make(chan chan struct{}),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	// Errors is read here rather than in the goroutine: the client clears the
+	// field on Close without synchronization
+	errs := w.api.Errors()
+	go func() {
+		for err := range errs {
+			log.Printf("Writing metrics to %s: %v", s.URL, err)
+		}
+	}()
+	go w.run()
+	return w
+}
+
+// enqueue queues points without blocking, dropping those that do not fit.
+func (w *writer) enqueue(points ...*write.Point) {
+	for _, p := range points {
+		select {
+		case w.queue <- p:
+		default:
+			w.drop()
+		}
+	}
+}
+
+func (w *writer) drop() {
+	n := w.dropped.Add(1)
+	now := time.Now().UnixNano()
+	last := w.lastLog.Load()
+	if now-last >= int64(dropLogInterval) && w.lastLog.CompareAndSwap(last, now) {
+		log.Printf("Metrics queue for %s full; %d points dropped so far", w.sink.URL, n)
+	}
+}
+
+func (w *writer) run() {
+	defer close(w.done)
+	for {
+		select {
+		case p := <-w.queue:
+			w.api.WritePoint(p)
+		case flushed := <-w.flush:
+			w.drain()
+			w.api.Flush()
+			close(flushed)
+		case <-w.stop:
+			w.drain()
+			// Close flushes what the WriteAPI still buffers
+			w.client.Close()
+			return
+		}
+	}
+}
+
+// drain hands every queued point to the WriteAPI.
+func (w *writer) drain() {
+	for {
+		select {
+		case p := <-w.queue:
+			w.api.WritePoint(p)
+		default:
+			return
+		}
+	}
+}
+
+// Flush waits until every point enqueued so far has been written.
+func (w *writer) Flush() {
+	flushed := make(chan struct{})
+	select {
+	case w.flush <- flushed:
+		<-flushed
+	case <-w.done:
+	}
+}
+
+// Close writes the remaining points and releases the client.
+func (w *writer) Close() {
+	close(w.stop)
+	<-w.done
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// TestWritePointsDoesNotBlock checks that an InfluxDB that never answers does
+// not hold up callers, and that points beyond the queue are dropped.
+func TestWritePointsDoesNotBlock(t *testing.T) {
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-hang }))
+	defer srv.Close()
+	defer close(hang)
+
+	w := newWriter(Sink{URL: srv.URL, Org: "org", Bucket: "bucket", Token: "token"})
+	start := time.Now()
+	for i := 0; i < 3*queueSize; i++ {
+		w.enqueue(influxdb2.NewPoint(Measurement, map[string]string{"endpoint": "/UserService/GetUser"}, map[string]interface{}{"duration": 0.1}, time.Now()))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("enqueueing took %v with InfluxDB hanging", elapsed)
+	}
+	if w.dropped.Load() == 0 {
+		t.Error("no points dropped past the queue size")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/influxtest"
+	"github.com/Ling-Qingran/gRPC-Observability/metrics"
+	"github.com/Ling-Qingran/gRPC-Observability/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+type stubUserService struct {
+	user.UnimplementedUserServiceServer
+}
+
+func (stubUserService) GetUser(ctx context.Context, req *user.GetUserRequest) (*user.User, error) {
+	if req.GetName() != "John Doe" {
+		return nil, grpcstatus.Error(codes.NotFound, "user not found")
+	}
+	return &user.User{Name: "John Doe", Age: "25", CommuteMethod: "Car", College: "Harvard University", Hobbies: "Reading"}, nil
+}
+
+// startMetricsServer serves stubUserService behind MetricsInterceptor over an
+// in-memory connection and points the metrics sink at a fake InfluxDB.
+func startMetricsServer(t *testing.T) (user.UserServiceClient, *influxtest.Server) {
+	t.Helper()
+
+	influx := influxtest.NewServer("test-org", "test-bucket", "test-token")
+	t.Cleanup(influx.Close)
+	metrics.SetSink(metrics.Sink{URL: influx.URL, Token: influx.Token, Org: influx.Org, Bucket: influx.Bucket})
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.UnaryInterceptor(MetricsInterceptor))
+	user.RegisterUserServiceServer(s, stubUserService{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUserAgent("metrics-test/1.2.3"),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return user.NewUserServiceClient(conn), influx
+}
+
+func TestMetricsInterceptorSuccess(t *testing.T) {
+	client, influx := startMetricsServer(t)
+
+	req := &user.GetUserRequest{Name: "John Doe"}
+	resp, err := client.GetUser(context.Background(), req)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+
+	points := influx.WaitFor(metrics.Measurement, 1, 5*time.Second)
+	if len(points) != 1 {
+		t.Fatalf("got %d %s points, want 1", len(points), metrics.Measurement)
+	}
+	p := points[0]
+
+	wantTags := map[string]string{
+		"endpoint":    "/UserService/GetUser",
+		"status_code": "OK",
+		"side":        "server",
+		"ip_address":  "bufconn",
+		"user_agent":  "metrics-test/1.2",
+	}
+	for k, want := range wantTags {
+		if got := p.Tags[k]; got != want {
+			t.Errorf("tag %s = %q, want %q", k, got, want)
+		}
+	}
+
+	wantFields := map[string]interface{}{
+		"error":         false,
+		"request_count": int64(1),
+		"error_rate":    int64(0),
+		"request_size":  int64(proto.Size(req)),
+		"response_size": int64(proto.Size(resp)),
+		"sample_weight": int64(1),
+	}
+	for k, want := range wantFields {
+		if got := p.Fields[k]; got != want {
+			t.Errorf("field %s = %v (%T), want %v (%T)", k, got, got, want, want)
+		}
+	}
+	if d, ok := p.Fields["duration"].(float64); !ok || d < 0 {
+		t.Errorf("field duration = %v, want a non-negative float", p.Fields["duration"])
+	}
+}
+
+func TestMetricsInterceptorError(t *testing.T) {
+	client, influx := startMetricsServer(t)
+
+	req := &user.GetUserRequest{Name: "Nobody"}
+	if _, err := client.GetUser(context.Background(), req); grpcstatus.Code(err) != codes.NotFound {
+		t.Fatalf("GetUser error = %v, want NotFound", err)
+	}
+
+	points := influx.WaitFor(metrics.Measurement, 1, 5*time.Second)
+	if len(points) != 1 {
+		t.Fatalf("got %d %s points, want 1", len(points), metrics.Measurement)
+	}
+	p := points[0]
+
+	if got := p.Tags["status_code"]; got != "NotFound" {
+		t.Errorf("tag status_code = %q, want NotFound", got)
+	}
+	wantFields := map[string]interface{}{
+		"error":         true,
+		"request_count": int64(1),
+		"error_rate":    int64(1),
+		"request_size":  int64(proto.Size(req)),
+		"response_size": int64(0),
+	}
+	for k, want := range wantFields {
+		if got := p.Fields[k]; got != want {
+			t.Errorf("field %s = %v (%T), want %v (%T)", k, got, got, want, want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// trustedProxies holds the networks whose forwarding headers are believed.
+// It is swapped on configuration reload.
+var trustedProxies atomic.Pointer[proxyList]
+
+// proxyList is a set of trusted proxy networks.
+type proxyList []*net.IPNet
+
+// parseProxies parses CIDRs or single addresses.
+func parseProxies(cidrs []string) (proxyList, error) {
+	var list proxyList
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is not an address or CIDR", c)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an address or CIDR", c)
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+func (l proxyList) contains(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range l {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddress returns a best-effort address for the caller of the RPC in ctx.
+// Behind a load balancer the transport peer is the proxy itself, so when the
+// peer is a trusted proxy the address it forwarded is used instead. Headers
+// from any other peer are ignored, as the caller can set them to anything.
+// It never fails: when nothing usable is found it returns "".
+func clientAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := peerAddress(p.Addr)
+	proxies := trustedProxies.Load()
+	if proxies == nil || !proxies.contains(addr) {
+		return addr
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if hops := forwardedHops(md); len(hops) > 0 {
+			return clientHop(hops, *proxies)
+		}
+	}
+	return addr
+}
+
+// clientHop picks the client from a chain of hops, nearest last: the
+// right-most hop that is not a trusted proxy, since everything left of it
+// was written by a party that is not trusted. A hop that is not an address
+// ("unknown" or an obfuscated RFC 7239 identifier) yields "". When every hop
+// is trusted the left-most one is the client.
+func clientHop(hops []string, proxies proxyList) string {
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr := normalizeHost(hops[i])
+		if addr == "" || !proxies.contains(addr) {
+			return addr
+		}
+	}
+	return normalizeHost(hops[0])
+}
+
+// forwardedHops returns the chain of hops from proxy metadata, client first.
+// X-Forwarded-For is preferred over Forwarded, then X-Real-IP. Repeated
+// headers are joined in order, as proxies may append one instead of
+// extending the list.
+func forwardedHops(md metadata.MD) []string {
+	var hops []string
+	if v := md.Get("x-forwarded-for"); len(v) > 0 {
+		for _, h := range strings.Split(strings.Join(v, ","), ",") {
+			hops = append(hops, strings.TrimSpace(h))
+		}
+		return hops
+	}
+
+	if v := md.Get("forwarded"); len(v) > 0 {
+		for _, element := range strings.Split(strings.Join(v, ","), ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hop = strings.Trim(value, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+
+	if v := md.Get("x-real-ip"); len(v) > 0 {
+		return v[len(v)-1:]
+	}
+	return nil
+}
+
+// peerAddress converts a transport address into a tag value. Unix sockets,
+// bufconn and other in-process listeners have no host:port form, so they are
+// reported by network name instead of being rejected.
+func peerAddress(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.UnixAddr:
+		if a.Name == "" || a.Name == "@" {
+			return "unix"
+		}
+		return "unix:" + a.Name
+	}
+
+	if host := normalizeHost(addr.String()); host != "" {
+		return host
+	}
+	return addr.Network()
+}
+
+// normalizeHost strips the port, brackets and IPv6 zone from a host or
+// host:port string. Values that are not recognisable addresses (such as the
+// "unknown" or obfuscated identifiers allowed by RFC 7239) yield "".
+func normalizeHost(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+
+	host := s
+	if h, _, err := net.SplitHostPort(s); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
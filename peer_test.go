@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestClientAddress(t *testing.T) {
+	proxies, err := parseProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustedProxies.Store(&proxies)
+	t.Cleanup(func() { trustedProxies.Store(nil) })
+
+	for _, tc := range []struct {
+		name string
+		peer string
+		md   metadata.MD
+		want string
+	}{
+		{"no headers", "203.0.113.7", nil, "203.0.113.7"},
+		{"spoofed by untrusted peer", "203.0.113.7", metadata.Pairs("x-forwarded-for", "1.2.3.4"), "203.0.113.7"},
+		{"spoofed x-real-ip", "203.0.113.7", metadata.Pairs("x-real-ip", "1.2.3.4"), "203.0.113.7"},
+		{"trusted proxy", "10.1.1.1", metadata.Pairs("x-forwarded-for", "198.51.100.2"), "198.51.100.2"},
+		{"trusted single address", "192.0.2.1", metadata.Pairs("x-real-ip", "198.51.100.2"), "198.51.100.2"},
+		{"trusted proxy without headers", "10.1.1.1", nil, "10.1.1.1"},
+		{"spoofed hop before client", "10.1.1.1", metadata.Pairs("x-forwarded-for", "1.2.3.4, 198.51.100.2"), "198.51.100.2"},
+		{"chain of trusted proxies", "10.1.1.1", metadata.Pairs("x-forwarded-for", "198.51.100.2, 10.2.2.2"), "198.51.100.2"},
+		{"repeated headers", "10.1.1.1", metadata.MD{"x-forwarded-for": {"1.2.3.4", "198.51.100.2, 10.2.2.2"}}, "198.51.100.2"},
+		{"all hops trusted", "10.1.1.1", metadata.Pairs("x-forwarded-for", "10.3.3.3, 10.2.2.2"), "10.3.3.3"},
+		{"forwarded", "10.1.1.1", metadata.Pairs("forwarded", `for=1.2.3.4, for="[2001:db8::1]:4711";proto=https`), "2001:db8::1"},
+		{"forwarded ipv6 with zone", "10.1.1.1", metadata.Pairs("forwarded", `for="[fe80::1%25eth0]:4711"`), "fe80::1"},
+		{"x-forwarded-for ipv6 with zone", "10.1.1.1", metadata.Pairs("x-forwarded-for", "fe80::1%eth0"), "fe80::1"},
+		{"unknown hop", "10.1.1.1", metadata.Pairs("forwarded", "for=1.2.3.4, for=unknown"), ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(tc.peer), Port: 4000}})
+			if tc.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tc.md)
+			}
+			if got := clientAddress(ctx); got != tc.want {
+				t.Errorf("clientAddress = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	for _, bad := range []string{"10.0.0.0/33", "proxy.internal", ""} {
+		if _, err := parseProxies([]string{bad}); err == nil {
+			t.Errorf("parseProxies(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestPeerAddress(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		addr net.Addr
+		want string
+	}{
+		{"tcp", &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4000}, "203.0.113.7"},
+		{"tcp ipv6 with zone", &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 4000, Zone: "eth0"}, "fe80::1"},
+		{"named unix socket", &net.UnixAddr{Name: "/run/grpc.sock", Net: "unix"}, "unix:/run/grpc.sock"},
+		{"unnamed unix socket", &net.UnixAddr{Name: "", Net: "unix"}, "unix"},
+		{"abstract unix socket", &net.UnixAddr{Name: "@", Net: "unix"}, "unix"},
+		{"other with zone", stringAddr("[fe80::1%eth0]:4000"), "fe80::1"},
+		{"other without address", stringAddr("bufconn"), "bufconn"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := peerAddress(tc.addr); got != tc.want {
+				t.Errorf("peerAddress = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// stringAddr is a net.Addr of an unknown type whose network and address are
+// both s.
+type stringAddr string
+
+func (a stringAddr) Network() string { return string(a) }
+func (a stringAddr) String() string  { return string(a) }
+
+func TestNormalizeHost(t *testing.T) {
+	for s, want := range map[string]string{
+		"198.51.100.2":          "198.51.100.2",
+		" 198.51.100.2:8080 ":   "198.51.100.2",
+		"2001:db8::1":           "2001:db8::1",
+		"[2001:db8::1]:4711":    "2001:db8::1",
+		"fe80::1%eth0":          "fe80::1",
+		"[fe80::1%25eth0]:4711": "fe80::1",
+		"[fe80::1%eth0]":        "fe80::1",
+		"unknown":               "",
+		"_hidden":               "",
+		"":                      "",
+	} {
+		if got := normalizeHost(s); got != want {
+			t.Errorf("normalizeHost(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestClientAddressUnixSocket(t *testing.T) {
+	proxies, err := parseProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustedProxies.Store(&proxies)
+	t.Cleanup(func() { trustedProxies.Store(nil) })
+
+	// A local socket is not a trusted proxy, so its headers are ignored
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.UnixAddr{Name: "/run/grpc.sock", Net: "unix"}})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", "198.51.100.2"))
+	if got := clientAddress(ctx); got != "unix:/run/grpc.sock" {
+		t.Errorf("clientAddress = %q, want unix:/run/grpc.sock", got)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Ling-Qingran/gRPC-Observability/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// newProbeHandler serves the HTTP probes used by Kubernetes and Cloud Run.
+//
+// /livez answers whether the process is alive. It does not look at
+// dependencies: restarting the server would not bring back a backend.
+//
+// /readyz answers whether the server should get traffic. It fails while the
+// required dependencies have not been checked yet, while one of them is
+// failing and while the server drains. Both return the CheckStatus response
+// as JSON so a failing probe explains itself.
+func newProbeHandler(s *statusServiceServer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, http.StatusOK, s.current())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		resp := s.current()
+		code := http.StatusOK
+		if resp.State != status.State_SERVING && resp.State != status.State_DEGRADED {
+			code = http.StatusServiceUnavailable
+		}
+		if !s.health.warmedUp() {
+			resp.Status = "Starting"
+		}
+		writeProbe(w, code, resp)
+	})
+	return mux
+}
+
+func writeProbe(w http.ResponseWriter, code int, resp *status.StatusResponse) {
+	b, err := protojson.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, "%s\n", b)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Ling-Qingran/gRPC-Observability/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// probe requests path from h and returns the status code and the decoded
+// body.
+func probe(t *testing.T, h http.Handler, path string) (int, *status.StatusResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s Content-Type = %q, want application/json", path, ct)
+	}
+	resp := &status.StatusResponse{}
+	if err := protojson.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+		t.Fatalf("%s body %q: %v", path, rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+func TestProbes(t *testing.T) {
+	s := newTestStatusServer()
+	h := newProbeHandler(s)
+
+	for _, step := range []struct {
+		name       string
+		apply      func()
+		wantReady  int
+		wantStatus string
+	}{
+		{"warming up", func() {}, http.StatusServiceUnavailable, "Starting"},
+		// An optional dependency being checked first does not end warm-up
+		{"optional checked", func() { s.health.report("metrics", nil) }, http.StatusServiceUnavailable, "Starting"},
+		{"ready", func() { s.health.report("storage", nil) }, http.StatusOK, "Up"},
+		{"optional failing", func() { s.health.report("metrics", errors.New("down")) }, http.StatusOK, "Degraded"},
+		{"required failing", func() { s.health.report("storage", errors.New("down")) }, http.StatusServiceUnavailable, "Down"},
+		{"recovered", func() { s.health.report("storage", nil) }, http.StatusOK, "Degraded"},
+		{"draining", s.drain, http.StatusServiceUnavailable, "Draining"},
+	} {
+		step.apply()
+		code, resp := probe(t, h, "/readyz")
+		if code != step.wantReady || resp.Status != step.wantStatus {
+			t.Errorf("%s: /readyz = %d %q, want %d %q", step.name, code, resp.Status, step.wantReady, step.wantStatus)
+		}
+		// Liveness ignores dependencies and draining
+		if code, _ := probe(t, h, "/livez"); code != http.StatusOK {
+			t.Errorf("%s: /livez = %d, want 200", step.name, code)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Package redact renders protobuf messages as JSON-compatible values with
+// sensitive fields masked, for logging and payload capture.
+package redact
+
+import (
+	"encoding/json"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Value replaces the value of every redacted field.
+const Value = "[REDACTED]"
+
+// Fields is a set of field names to redact. Names match proto (commute_method)
+// and JSON (commuteMethod) spellings alike, at any depth.
+type Fields map[string]bool
+
+// NewFields builds Fields from a list of names.
+func NewFields(names []string) Fields {
+	fields := Fields{}
+	for _, f := range names {
+		if f = strings.TrimSpace(f); f != "" {
+			fields[key(f)] = true
+		}
+	}
+	return fields
+}
+
+// Has reports whether name is redacted.
+func (f Fields) Has(name string) bool {
+	return f[key(name)]
+}
+
+// Message renders m in its protojson form with the fields in f replaced by
+// Value. It returns nil if m is not a protobuf message.
+func Message(m interface{}, f Fields) interface{} {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return nil
+	}
+	b, err := protojson.Marshal(msg)
+	if err != nil {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil
+	}
+	return apply(v, f)
+}
+
+func apply(v interface{}, f Fields) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, inner := range v {
+			if f.Has(k) {
+				v[k] = Value
+			} else {
+				v[k] = apply(inner, f)
+			}
+		}
+	case []interface{}:
+		for i, inner := range v {
+			v[i] = apply(inner, f)
+		}
+	}
+	return v
+}
+
+func key(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
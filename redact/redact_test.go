@@ -0,0 +1,48 @@
+package redact
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Ling-Qingran/gRPC-Observability/user"
+)
+
+func TestFields(t *testing.T) {
+	f := NewFields([]string{" commute_method ", "", "Age"})
+	for name, want := range map[string]bool{
+		"commute_method": true,
+		"commuteMethod":  true,
+		"CommuteMethod":  true,
+		"age":            true,
+		"name":           false,
+		"":               false,
+	} {
+		if got := f.Has(name); got != want {
+			t.Errorf("Has(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	req := &user.CreateUserRequest{User: &user.User{Name: "John Doe", Age: "25", CommuteMethod: "Car", Hobbies: "Reading"}}
+	got, err := json.Marshal(Message(req, NewFields([]string{"name", "commute_method"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"user":{"age":"25","commuteMethod":"[REDACTED]","hobbies":"Reading","name":"[REDACTED]"}}`
+	if string(got) != want {
+		t.Errorf("Message = %s, want %s", got, want)
+	}
+
+	// Without redacted fields the message is rendered as is
+	got, _ = json.Marshal(Message(&user.GetUserRequest{Name: "John Doe"}, nil))
+	if want := `{"name":"John Doe"}`; string(got) != want {
+		t.Errorf("Message without fields = %s, want %s", got, want)
+	}
+}
+
+func TestMessageIgnoresNonProto(t *testing.T) {
+	if got := Message("not a message", NewFields([]string{"name"})); got != nil {
+		t.Errorf("Message(string) = %v, want nil", got)
+	}
+}
@@ -0,0 +1,192 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// writeTimeout bounds a single write, so a heartbeat cannot block on a
+// connection whose send buffer is full.
+const writeTimeout = 10 * time.Second
+
+// missedHeartbeats is how many heartbeat intervals may pass without anything
+// from the registry (a reply or a pong) before the connection is considered
+// dead. Writes alone do not notice a half-open connection until TCP gives up.
+const missedHeartbeats = 3
+
+// deregisterTimeout bounds the deregistration sent on shutdown, which has to
+// fit in the shutdown deadline.
+const deregisterTimeout = 2 * time.Second
+
+// Client keeps a service registered with the WebSocket registry. It holds one
+// connection open, sends the registration when it connects and a heartbeat
+// every HeartbeatInterval, and reconnects with exponential backoff and jitter
+// when the connection fails. Heartbeats renew the lease set by
+// Registration.TTLSeconds.
+type Client struct {
+	// URL is the registry's WebSocket endpoint.
+	URL          string
+	Registration Registration
+	Options
+
+	Dialer *websocket.Dialer
+}
+
+// NewClient returns a Client with default intervals.
+func NewClient(url string, reg Registration) *Client {
+	return &Client{
+		URL:          url,
+		Registration: reg,
+		Options:      DefaultOptions(),
+		Dialer:       websocket.DefaultDialer,
+	}
+}
+
+// Run keeps the service registered until ctx is done, then deregisters it
+// if connected and returns.
+func (c *Client) Run(ctx context.Context) {
+	failures := 0
+	for {
+		start := time.Now()
+		registered, err := c.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		// Start over only after a connection that lasted, so a registry that
+		// accepts and then drops every connection still backs us off
+		if registered && time.Since(start) >= c.HeartbeatInterval {
+			failures = 0
+		}
+		c.report(err)
+
+		delay := c.backoff(failures)
+		failures++
+		log.Printf("Registry connection to %s failed, reconnecting in %v: %v", c.URL, delay.Round(time.Millisecond), err)
+		if !sleep(ctx, delay) {
+			return
+		}
+	}
+}
+
+// session runs one connection until it fails or ctx is done. registered
+// reports whether the registration was sent.
+func (c *Client) session(ctx context.Context) (registered bool, err error) {
+	conn, _, err := c.Dialer.DialContext(ctx, c.URL, nil)
+	if err != nil {
+		return false, fmt.Errorf("connecting: %w", err)
+	}
+	defer conn.Close()
+
+	if err := c.send(conn, Message{Op: OpRegister, Registration: c.Registration}); err != nil {
+		return false, fmt.Errorf("registering: %w", err)
+	}
+	log.Printf("Registered %s with %s", c.Registration.Name, c.URL)
+	c.report(nil)
+
+	// Every heartbeat goes with a ping, and any message or pong from the
+	// registry renews the read deadline
+	readTimeout := missedHeartbeats * c.HeartbeatInterval
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+
+	// Only this goroutine writes; the reader hands messages over
+	incoming := make(chan Message)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			_, b, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(readTimeout))
+			var m Message
+			if err := json.Unmarshal(b, &m); err != nil {
+				// Registries predating ops answer in plain text
+				log.Printf("Response from registry: %s", b)
+				continue
+			}
+			select {
+			case incoming <- m:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(c.HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.deregister(conn)
+			return true, nil
+		case <-heartbeat.C:
+			if err := c.send(conn, c.heartbeat()); err != nil {
+				return true, fmt.Errorf("sending heartbeat: %w", err)
+			}
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				return true, fmt.Errorf("sending ping: %w", err)
+			}
+		case m := <-incoming:
+			if err := c.handle(conn, m); err != nil {
+				return true, err
+			}
+		case err := <-readErr:
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				return true, fmt.Errorf("registry closed the connection: %w", err)
+			}
+			return true, fmt.Errorf("reading: %w", err)
+		}
+	}
+}
+
+// handle processes a message initiated by the registry.
+func (c *Client) handle(conn *websocket.Conn, m Message) error {
+	switch m.Op {
+	case OpAck:
+	case OpReregister:
+		log.Printf("Registry %s asked to register again", c.URL)
+		if err := c.send(conn, Message{Op: OpRegister, Registration: c.Registration}); err != nil {
+			return fmt.Errorf("registering: %w", err)
+		}
+	case OpError:
+		log.Printf("Registry %s rejected a message: %s", c.URL, m.Message)
+	default:
+		log.Printf("Ignoring registry message with unknown op %q", m.Op)
+	}
+	return nil
+}
+
+// deregister withdraws the registration and closes the connection. Failures
+// are only logged: the lease expires the registration anyway.
+func (c *Client) deregister(conn *websocket.Conn) {
+	deadline := time.Now().Add(deregisterTimeout)
+	conn.SetWriteDeadline(deadline)
+	if err := conn.WriteJSON(Message{Op: OpDeregister, Registration: c.Registration.identity()}); err != nil {
+		log.Printf("Failed to deregister %s from %s: %v", c.Registration.Name, c.URL, err)
+		return
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), deadline)
+	log.Printf("Deregistered %s from %s", c.Registration.Name, c.URL)
+}
+
+func (c *Client) heartbeat() Message {
+	return Message{Op: OpHeartbeat, Registration: c.Registration.identity(), Health: c.health()}
+}
+
+func (c *Client) send(conn *websocket.Conn, m Message) error {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return conn.WriteJSON(m)
+}
@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileRegistrar keeps a service listed in a static file of DNS SRV records,
+// one line per instance, for environments that discover services from a
+// shared file or a DNS zone generated from one:
+//
+//	_student-info-grpc-service-cloud._tcp 30 IN SRV 0 10 8080 localhost. ; instance=5b0e...
+//
+// Lines of other instances are kept. The instance's line is rewritten every
+// HeartbeatInterval, which puts it back if something removed it, and is
+// left out while the instance reports NOT_SERVING or DRAINING. Run removes
+// it on return.
+type FileRegistrar struct {
+	Path         string
+	Registration Registration
+	Options
+}
+
+// NewFileRegistrar returns a FileRegistrar with default intervals.
+func NewFileRegistrar(path string, reg Registration) *FileRegistrar {
+	return &FileRegistrar{Path: path, Registration: reg, Options: DefaultOptions()}
+}
+
+// Run keeps the record current until ctx is done, then removes it.
+func (f *FileRegistrar) Run(ctx context.Context) {
+	listed := false
+	for {
+		serving := true
+		if h := f.health(); h != nil {
+			serving = servingState(h.State)
+		}
+		err := f.update(serving)
+		f.report(err)
+		if err != nil {
+			log.Printf("Failed to update registry file %s: %v", f.Path, err)
+		} else if serving != listed {
+			listed = serving
+			log.Printf("Registry file %s: %s %s", f.Path, map[bool]string{true: "listed", false: "unlisted"}[listed], f.Registration.Name)
+		}
+		if !sleep(ctx, f.HeartbeatInterval) {
+			break
+		}
+	}
+	if err := f.update(false); err != nil {
+		log.Printf("Failed to deregister %s from %s: %v", f.Registration.Name, f.Path, err)
+		return
+	}
+	log.Printf("Deregistered %s from %s", f.Registration.Name, f.Path)
+}
+
+// record is the instance's line.
+func (f *FileRegistrar) record() string {
+	r := f.Registration
+	target := r.Host
+	if !strings.HasSuffix(target, ".") {
+		target += "."
+	}
+	return fmt.Sprintf("_%s._tcp %d IN SRV 0 10 %d %s ; instance=%s", srvLabel(r.Name), r.TTLSeconds, r.Port, target, instanceKey(r))
+}
+
+// srvLabel turns a service name into a DNS label: lower case, with runs of
+// anything but letters and digits replaced by a dash.
+func srvLabel(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(name) {
+		if ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			b.WriteRune(c)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// update rewrites the file with the instance's line present or absent. The
+// file is replaced atomically, under an exclusive lock on Path+".lock" so
+// instances sharing the file do not lose each other's lines.
+func (f *FileRegistrar) update(present bool) error {
+	lock, err := os.OpenFile(f.Path+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	unlock, err := lockFile(lock)
+	if err != nil {
+		return fmt.Errorf("locking %s: %w", lock.Name(), err)
+	}
+	defer unlock()
+
+	b, err := os.ReadFile(f.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	suffix := "; instance=" + instanceKey(f.Registration)
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line != "" && !strings.HasSuffix(line, suffix) {
+			lines = append(lines, line)
+		}
+	}
+	if present {
+		lines = append(lines, f.record())
+	}
+	content := strings.Join(lines, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if content == string(b) {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileRegistrar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.srv")
+	other := "_orders._tcp 30 IN SRV 0 10 9000 10.0.0.2. ; instance=orders-1\n"
+	if err := os.WriteFile(path, []byte(other), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	read := func() string {
+		t.Helper()
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	var state atomic.Value
+	state.Store("")
+	registrar := NewFileRegistrar(path, testRegistration)
+	registrar.HeartbeatInterval = time.Millisecond
+	listed := make(chan bool, 100)
+	registrar.OnStatus = func(err error) {
+		if err != nil {
+			t.Errorf("update: %v", err)
+		}
+		select {
+		case listed <- strings.Contains(read(), "; instance=instance-1"):
+		default:
+		}
+	}
+	registrar.Health = func() Health { return Health{State: state.Load().(string)} }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		registrar.Run(ctx)
+		close(done)
+	}()
+
+	if !<-listed {
+		t.Fatal("instance not listed")
+	}
+	want := other + "_userservice._tcp 30 IN SRV 0 10 8080 10.0.0.1. ; instance=instance-1\n"
+	if got := read(); got != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+
+	// Left out while not serving, and listed again on recovery
+	for _, s := range []string{"NOT_SERVING", "SERVING", "DRAINING"} {
+		state.Store(s)
+		wantListed := servingState(s)
+		for got := <-listed; got != wantListed; got = <-listed {
+		}
+		if got, want := strings.Contains(read(), "; instance=instance-1"), wantListed; got != want {
+			t.Errorf("state %s: listed = %v, want %v", s, got, want)
+		}
+		if !strings.HasPrefix(read(), other) {
+			t.Errorf("state %s: file = %q, lost the other instance", s, read())
+		}
+	}
+
+	cancel()
+	<-done
+	if got := read(); got != other {
+		t.Errorf("file after shutdown = %q, want %q", got, other)
+	}
+}
+
+func TestSRVLabel(t *testing.T) {
+	for name, want := range map[string]string{
+		"UserService":                     "userservice",
+		"Student-Info gRPC Service Cloud": "student-info-grpc-service-cloud",
+		" a__b ":                          "a-b",
+	} {
+		if got := srvLabel(name); got != want {
+			t.Errorf("srvLabel(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
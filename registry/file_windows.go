@@ -0,0 +1,20 @@
+//go:build windows
+
+package registry
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile takes an exclusive lock on the first byte of f, waiting for other
+// holders.
+func lockFile(f *os.File) (unlock func(), err error) {
+	h := windows.Handle(f.Fd())
+	ol := new(windows.Overlapped)
+	if err := windows.LockFileEx(h, windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, ol); err != nil {
+		return nil, err
+	}
+	return func() { windows.UnlockFileEx(h, 0, 1, 0, ol) }, nil
+}
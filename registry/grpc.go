@@ -0,0 +1,101 @@
+package registry
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/Ling-Qingran/gRPC-Observability/registrypb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// snapshotSizeHeader is the Watch response header holding the number of
+// ADDED events that make up the current membership, so a watcher knows when
+// it has all of it, including when there is none.
+const snapshotSizeHeader = "registry-snapshot-size"
+
+// GRPC returns the ServiceRegistry gRPC service backed by s.
+func (s *Server) GRPC() registrypb.ServiceRegistryServer {
+	return grpcServer{s: s}
+}
+
+type grpcServer struct {
+	registrypb.UnimplementedServiceRegistryServer
+	s *Server
+}
+
+func (g grpcServer) ListServices(ctx context.Context, req *registrypb.ListServicesRequest) (*registrypb.ListServicesResponse, error) {
+	resp := &registrypb.ListServicesResponse{}
+	for _, inst := range g.s.Instances(req.GetName()) {
+		resp.Instances = append(resp.Instances, inst.proto())
+	}
+	return resp, nil
+}
+
+func (g grpcServer) Watch(req *registrypb.WatchRequest, stream registrypb.ServiceRegistry_WatchServer) error {
+	snapshot, events, cancel := g.s.Subscribe(req.GetName())
+	defer cancel()
+
+	if err := stream.SendHeader(metadata.Pairs(snapshotSizeHeader, strconv.Itoa(len(snapshot)))); err != nil {
+		return err
+	}
+	for _, inst := range snapshot {
+		if err := stream.Send(&registrypb.MembershipEvent{Type: registrypb.MembershipEvent_ADDED, Instance: inst.proto()}); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case e, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "subscriber fell too far behind; watch again")
+			}
+			if err := stream.Send(e.proto()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+var eventTypes = map[string]registrypb.MembershipEvent_Type{
+	EventAdded:   registrypb.MembershipEvent_ADDED,
+	EventUpdated: registrypb.MembershipEvent_UPDATED,
+	EventRemoved: registrypb.MembershipEvent_REMOVED,
+}
+
+func (e Event) proto() *registrypb.MembershipEvent {
+	return &registrypb.MembershipEvent{Type: eventTypes[e.Type], Instance: e.Instance.proto()}
+}
+
+func (inst Instance) proto() *registrypb.Instance {
+	p := &registrypb.Instance{
+		Key:        inst.Key,
+		Name:       inst.Name,
+		Host:       inst.Host,
+		Port:       int32(inst.Port),
+		Type:       inst.Type,
+		Version:    inst.Version,
+		InstanceId: inst.InstanceID,
+		Region:     inst.Region,
+		Zone:       inst.Zone,
+		Tls:        inst.TLS,
+		Tags:       inst.Tags,
+		State:      inst.Health.State,
+		Unhealthy:  inst.Health.Unhealthy,
+		Registered: timestamppb.New(inst.Registered),
+		LastSeen:   timestamppb.New(inst.LastSeen),
+		Expires:    timestamppb.New(inst.Expires),
+	}
+	for _, svc := range inst.Services {
+		ps := &registrypb.GRPCService{Name: svc.Name}
+		for _, m := range svc.Methods {
+			ps.Methods = append(ps.Methods, &registrypb.Method{Name: m.Name, ClientStreaming: m.ClientStreaming, ServerStreaming: m.ServerStreaming})
+		}
+		p.Services = append(p.Services, ps)
+	}
+	return p
+}
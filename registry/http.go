@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// HTTPRegistrar keeps a service registered with a registry that takes the
+// registration protocol as HTTP requests: every message is POSTed as JSON to
+// URL and answered with one message. Without a connection to watch, the
+// registry relies on heartbeats and the lease to tell the service is alive.
+type HTTPRegistrar struct {
+	// URL receives the messages, e.g. http://localhost:8090/register.
+	URL          string
+	Registration Registration
+	Options
+
+	Client *http.Client
+}
+
+// NewHTTPRegistrar returns an HTTPRegistrar with default intervals.
+func NewHTTPRegistrar(url string, reg Registration) *HTTPRegistrar {
+	return &HTTPRegistrar{
+		URL:          url,
+		Registration: reg,
+		Options:      DefaultOptions(),
+		Client:       &http.Client{Timeout: writeTimeout},
+	}
+}
+
+// Run registers, then sends a heartbeat every HeartbeatInterval until ctx
+// is done, and deregisters. Failed requests are retried with backoff;
+// registering again when the registry has forgotten the instance.
+func (h *HTTPRegistrar) Run(ctx context.Context) {
+	registered := false
+	failures := 0
+	for {
+		m := Message{Op: OpHeartbeat, Registration: h.Registration.identity(), Health: h.health()}
+		if !registered {
+			m = Message{Op: OpRegister, Registration: h.Registration}
+		}
+		reply, err := h.send(ctx, m)
+		if ctx.Err() != nil {
+			// The registry may have taken a register cut short
+			registered = registered || m.Op == OpRegister
+			break
+		}
+
+		delay := h.HeartbeatInterval
+		switch {
+		case err != nil:
+			delay = h.backoff(failures)
+			failures++
+			log.Printf("Registry request to %s failed, retrying in %v: %v", h.URL, delay.Round(time.Millisecond), err)
+			h.report(err)
+		case reply.Op == OpReregister:
+			registered, delay = false, 0
+		default:
+			if !registered {
+				log.Printf("Registered %s with %s", h.Registration.Name, h.URL)
+			}
+			registered, failures = true, 0
+			h.report(nil)
+		}
+		if !sleep(ctx, delay) {
+			break
+		}
+	}
+
+	if registered {
+		ctx, cancel := context.WithTimeout(context.Background(), deregisterTimeout)
+		defer cancel()
+		if _, err := h.send(ctx, Message{Op: OpDeregister, Registration: h.Registration.identity()}); err != nil {
+			log.Printf("Failed to deregister %s from %s: %v", h.Registration.Name, h.URL, err)
+			return
+		}
+		log.Printf("Deregistered %s from %s", h.Registration.Name, h.URL)
+	}
+}
+
+// send POSTs m and returns the reply. An OpError reply is an error.
+func (h *HTTPRegistrar) send(ctx context.Context, m Message) (Message, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return Message{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(b))
+	if err != nil {
+		return Message{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return Message{}, err
+	}
+	defer resp.Body.Close()
+
+	var reply Message
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return Message{}, fmt.Errorf("%s: decoding reply: %w", resp.Status, err)
+	}
+	if reply.Op == OpError {
+		return reply, fmt.Errorf("registry rejected %s: %s", m.Op, reply.Message)
+	}
+	return reply, nil
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Registrar announces a service instance to a discovery mechanism.
+type Registrar interface {
+	// Run registers the instance and keeps the registration current until
+	// ctx is done, then withdraws it and returns.
+	Run(ctx context.Context)
+}
+
+var (
+	_ Registrar = (*Client)(nil)
+	_ Registrar = (*HTTPRegistrar)(nil)
+	_ Registrar = (*FileRegistrar)(nil)
+)
+
+// Options are the settings shared by every Registrar.
+type Options struct {
+	// HeartbeatInterval is the time between renewals of the registration.
+	HeartbeatInterval time.Duration
+	// MinBackoff and MaxBackoff bound the delay between retries.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Health returns the state sent with heartbeats. It may be nil.
+	Health func() Health
+	// OnStatus, if set, is called with nil once registered and with the
+	// error whenever registering or renewing fails.
+	OnStatus func(error)
+}
+
+// DefaultOptions returns the default intervals.
+func DefaultOptions() Options {
+	return Options{
+		HeartbeatInterval: 10 * time.Second,
+		MinBackoff:        time.Second,
+		MaxBackoff:        time.Minute,
+	}
+}
+
+// backoff returns the delay before retry n: MinBackoff doubled n times,
+// capped at MaxBackoff, with up to half of it taken off at random so that
+// services restarted together do not retry in lockstep.
+func (o Options) backoff(n int) time.Duration {
+	d := o.MinBackoff
+	for i := 0; i < n && d < o.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, o.MaxBackoff)
+	return d - time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+func (o Options) report(err error) {
+	if o.OnStatus != nil {
+		o.OnStatus(err)
+	}
+}
+
+func (o Options) health() *Health {
+	if o.Health == nil {
+		return nil
+	}
+	h := o.Health()
+	return &h
+}
+
+// sleep waits for d or until ctx is done, reporting whether d elapsed.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,122 @@
+// Package registry announces the server to the service registry over a
+// long-lived WebSocket connection.
+//
+// Messages are JSON objects with an "op" field. Registrations keep the
+// original flat layout (name, host, port, type) with op added alongside, so a
+// registry that only understands the original payload still reads them.
+package registry
+
+import (
+	"sort"
+
+	"google.golang.org/grpc"
+)
+
+// Operations carried in the "op" field.
+const (
+	// OpRegister announces a service. Sent by the service on every connect.
+	OpRegister = "register"
+	// OpHeartbeat tells the registry the service is alive and how healthy
+	// it is.
+	OpHeartbeat = "heartbeat"
+	// OpDeregister withdraws a registration. Sent by the service when it
+	// shuts down gracefully.
+	OpDeregister = "deregister"
+	// OpAck confirms a registration. Sent by the registry.
+	OpAck = "ack"
+	// OpReregister asks the service to send its registration again, e.g.
+	// after the registry restarted and lost its state. Sent by the registry.
+	OpReregister = "reregister"
+	// OpError reports a rejected message. Sent by the registry.
+	OpError = "error"
+)
+
+// Registration describes a service instance.
+type Registration struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	Type string `json:"type"`
+
+	Version string `json:"version,omitempty"`
+	// InstanceID tells apart instances of the same service, which may share a
+	// host and port behind a proxy.
+	InstanceID string `json:"instance_id,omitempty"`
+	Region     string `json:"region,omitempty"`
+	Zone       string `json:"zone,omitempty"`
+	// TLS reports whether the advertised port expects TLS.
+	TLS  bool              `json:"tls,omitempty"`
+	Tags map[string]string `json:"tags,omitempty"`
+	// Services lists the gRPC services and methods the instance serves.
+	Services []Service `json:"services,omitempty"`
+
+	// TTLSeconds is the lease: the registry may drop the registration when
+	// it has heard nothing from the service for this long, which covers a
+	// service that dies without deregistering. Zero means no lease.
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+}
+
+// identity returns the fields that identify the instance, which is all
+// heartbeats and deregistrations need to carry.
+func (r Registration) identity() Registration {
+	return Registration{Name: r.Name, Host: r.Host, Port: r.Port, Type: r.Type, InstanceID: r.InstanceID}
+}
+
+// Service is a gRPC service in the method catalog.
+type Service struct {
+	// Name is the full service name, e.g. "UserService".
+	Name    string   `json:"name"`
+	Methods []Method `json:"methods"`
+}
+
+// Method is a gRPC method in the method catalog.
+type Method struct {
+	Name            string `json:"name"`
+	ClientStreaming bool   `json:"client_streaming,omitempty"`
+	ServerStreaming bool   `json:"server_streaming,omitempty"`
+}
+
+// ServicesOf builds the method catalog of s from the services registered on
+// it, sorted by name.
+func ServicesOf(s *grpc.Server) []Service {
+	info := s.GetServiceInfo()
+	services := make([]Service, 0, len(info))
+	for name, svc := range info {
+		methods := make([]Method, 0, len(svc.Methods))
+		for _, m := range svc.Methods {
+			methods = append(methods, Method{Name: m.Name, ClientStreaming: m.IsClientStream, ServerStreaming: m.IsServerStream})
+		}
+		sort.Slice(methods, func(i, j int) bool { return methods[i].Name < methods[j].Name })
+		services = append(services, Service{Name: name, Methods: methods})
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services
+}
+
+// Health is the state reported with each heartbeat.
+type Health struct {
+	// State is the overall state, e.g. SERVING or DEGRADED.
+	State string `json:"state"`
+	// Unhealthy names the dependencies failing their checks.
+	Unhealthy []string `json:"unhealthy,omitempty"`
+}
+
+// servingState reports whether an instance in state should get calls. An
+// empty state, from an instance that has not sent a heartbeat yet, counts as
+// serving.
+func servingState(state string) bool {
+	switch state {
+	case "NOT_SERVING", "DRAINING":
+		return false
+	}
+	return true
+}
+
+// Message is any message exchanged with the registry.
+type Message struct {
+	Op string `json:"op"`
+	Registration
+	Health *Health `json:"health,omitempty"`
+	// Message explains an OpError or OpAck.
+	Message string `json:"message,omitempty"`
+}
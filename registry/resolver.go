@@ -0,0 +1,218 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/registrypb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	// Enables the client-side health checking in ServiceConfig
+	_ "google.golang.org/grpc/health"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the target scheme resolved from the registry, as in
+// "registry:///UserService" or "registry://registry.internal:8091/UserService".
+const Scheme = "registry"
+
+// ServiceConfig balances calls across the resolved instances with round_robin
+// and checks each one with the gRPC health service. Pass it with
+// grpc.WithDefaultServiceConfig when dialing a registry target without the
+// client package, whose default service config already includes it.
+const ServiceConfig = `{
+	"loadBalancingConfig": [{"round_robin": {}}],
+	"healthCheckConfig": {"serviceName": ""}
+}`
+
+// RegisterResolver installs the registry resolver for Scheme. Targets without
+// an authority look instances up in the registry whose gRPC service is at
+// addr. opts are used to dial the registry.
+func RegisterResolver(addr string, opts ...grpc.DialOption) {
+	resolver.Register(&ResolverBuilder{Registry: addr, DialOptions: opts})
+}
+
+// ResolverBuilder resolves registry targets. The target's endpoint is the
+// service name; each instance the registry reports becomes one address.
+// Instances that report NOT_SERVING or DRAINING in their heartbeats are left
+// out.
+//
+// Instances are dialed with the channel's transport credentials whatever
+// their TLS flag says, so a channel should only target services whose
+// instances all expect the same credentials.
+type ResolverBuilder struct {
+	// Registry is the address of the registry's gRPC service.
+	Registry string
+	// DialOptions are used to dial the registry. Plaintext by default.
+	DialOptions []grpc.DialOption
+}
+
+// Scheme implements resolver.Builder.
+func (b *ResolverBuilder) Scheme() string {
+	return Scheme
+}
+
+// Build implements resolver.Builder. It watches the registry until the
+// resolver is closed.
+func (b *ResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	addr := target.URL.Host
+	if addr == "" {
+		addr = b.Registry
+	}
+	name := target.Endpoint()
+	if addr == "" || name == "" {
+		return nil, fmt.Errorf("registry target %q needs a service name and a registry address", target.URL.String())
+	}
+
+	opts := append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, b.DialOptions...)
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("dialing registry %s: %w", addr, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &registryResolver{
+		cc:        cc,
+		name:      name,
+		client:    registrypb.NewServiceRegistryClient(conn),
+		conn:      conn,
+		cancel:    cancel,
+		instances: map[string]*registrypb.Instance{},
+	}
+	r.wg.Add(1)
+	go r.watch(ctx)
+	return r, nil
+}
+
+// registryResolver keeps the addresses of one service up to date from a
+// registry Watch stream.
+type registryResolver struct {
+	cc     resolver.ClientConn
+	name   string
+	client registrypb.ServiceRegistryClient
+	conn   *grpc.ClientConn
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// instances is only used by the watch goroutine.
+	instances map[string]*registrypb.Instance
+}
+
+// watch follows the registry, watching again with exponential backoff when
+// the stream fails. The last known addresses stay in use meanwhile.
+func (r *registryResolver) watch(ctx context.Context) {
+	defer r.wg.Done()
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := r.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("Registry watch for %s failed, retrying in %v: %v", r.name, backoff, err)
+		if len(r.instances) == 0 {
+			r.cc.ReportError(fmt.Errorf("registry watch for %s: %w", r.name, err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// follow applies the events of one Watch stream until it fails.
+func (r *registryResolver) follow(ctx context.Context) error {
+	stream, err := r.client.Watch(ctx, &registrypb.WatchRequest{Name: r.name})
+	if err != nil {
+		return err
+	}
+	// A new stream starts with the full membership. The addresses are only
+	// replaced once all of it arrived, so an empty service clears them and a
+	// partial list is never used. Registries that do not announce its size
+	// get an update per event.
+	r.instances = map[string]*registrypb.Instance{}
+	pending := snapshotSize(stream)
+	if pending == 0 {
+		r.update()
+	}
+	for {
+		e, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		switch e.GetType() {
+		case registrypb.MembershipEvent_ADDED, registrypb.MembershipEvent_UPDATED:
+			r.instances[e.GetInstance().GetKey()] = e.GetInstance()
+		case registrypb.MembershipEvent_REMOVED:
+			delete(r.instances, e.GetInstance().GetKey())
+		}
+		if pending > 0 {
+			pending--
+			if pending > 0 {
+				continue
+			}
+		}
+		r.update()
+	}
+}
+
+// snapshotSize returns the number of events making up the current membership
+// at the start of stream, or -1 if the registry does not say.
+func snapshotSize(stream registrypb.ServiceRegistry_WatchClient) int {
+	md, err := stream.Header()
+	if err != nil {
+		return -1
+	}
+	v := md.Get(snapshotSizeHeader)
+	if len(v) != 1 {
+		return -1
+	}
+	n, err := strconv.Atoi(v[0])
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// update sends the addresses of the usable instances to gRPC.
+func (r *registryResolver) update() {
+	var addrs []resolver.Address
+	for _, inst := range r.instances {
+		if !usable(inst) {
+			continue
+		}
+		addrs = append(addrs, resolver.Address{
+			Addr:       net.JoinHostPort(inst.GetHost(), strconv.Itoa(int(inst.GetPort()))),
+			ServerName: inst.GetHost(),
+		})
+	}
+	if err := r.cc.UpdateState(resolver.State{Addresses: addrs}); err != nil {
+		log.Printf("Registry resolver for %s: %v", r.name, err)
+	}
+}
+
+// usable reports whether calls should be sent to inst.
+func usable(inst *registrypb.Instance) bool {
+	return servingState(inst.GetState())
+}
+
+// ResolveNow implements resolver.Resolver. Nothing to do: the registry pushes
+// every change.
+func (r *registryResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+// Close implements resolver.Resolver.
+func (r *registryResolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+	r.conn.Close()
+}
@@ -0,0 +1,201 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/registrypb"
+	"github.com/Ling-Qingran/gRPC-Observability/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// namedStatusService answers CheckStatus with its name as the version, so a
+// test can tell which backend served a call.
+type namedStatusService struct {
+	status.UnimplementedStatusServiceServer
+	name string
+}
+
+func (s namedStatusService) CheckStatus(context.Context, *status.StatusRequest) (*status.StatusResponse, error) {
+	return &status.StatusResponse{Version: s.name}, nil
+}
+
+// startBackend serves namedStatusService and the health service on a local
+// port and returns its registration.
+func startBackend(t *testing.T, name string) (Registration, *health.Server) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := grpc.NewServer()
+	status.RegisterStatusServiceServer(s, namedStatusService{name: name})
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	addr := lis.Addr().(*net.TCPAddr)
+	return Registration{Name: "StatusService", Host: "127.0.0.1", Port: addr.Port, Type: "gRPC", InstanceID: name, TTLSeconds: 60}, healthServer
+}
+
+// servedBy makes calls until every name in want has answered and no other
+// has, or fails after a few seconds.
+func servedBy(t *testing.T, client status.StatusServiceClient, want ...string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		seen := map[string]bool{}
+		for i := 0; i < 20; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			resp, err := client.CheckStatus(ctx, &status.StatusRequest{})
+			cancel()
+			if err == nil {
+				seen[resp.Version] = true
+			}
+		}
+		ok := len(seen) == len(want)
+		for _, name := range want {
+			ok = ok && seen[name]
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("calls served by %v, want exactly %v", seen, want)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestResolverFollowsRegistry(t *testing.T) {
+	reg := NewServer()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	registrypb.RegisterServiceRegistryServer(s, reg.GRPC())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	a, aHealth := startBackend(t, "a")
+	b, _ := startBackend(t, "b")
+	reg.register(a, nil, time.Now())
+	reg.register(b, nil, time.Now())
+	reg.heartbeat(b.identity(), &Health{State: "NOT_SERVING"}, nil, time.Now())
+
+	builder := &ResolverBuilder{
+		Registry: "bufnet",
+		DialOptions: []grpc.DialOption{
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		},
+	}
+	conn, err := grpc.Dial(Scheme+":///StatusService",
+		grpc.WithResolvers(builder),
+		grpc.WithDefaultServiceConfig(ServiceConfig),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := status.NewStatusServiceClient(conn)
+
+	// b reports NOT_SERVING, so only a gets calls
+	servedBy(t, client, "a")
+
+	// Once b recovers, calls are balanced across both
+	reg.heartbeat(b.identity(), &Health{State: "SERVING"}, nil, time.Now())
+	servedBy(t, client, "a", "b")
+
+	// A backend failing its own health check is skipped even while the
+	// registry has not heard about it yet
+	aHealth.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	servedBy(t, client, "b")
+	aHealth.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	servedBy(t, client, "a", "b")
+
+	// A removed instance stops getting calls
+	reg.deregister(a, nil)
+	servedBy(t, client, "b")
+}
+
+// recordingClientConn records the address lists a resolver reports.
+type recordingClientConn struct {
+	resolver.ClientConn
+	states chan []resolver.Address
+}
+
+func (cc *recordingClientConn) UpdateState(s resolver.State) error {
+	cc.states <- s.Addresses
+	return nil
+}
+
+func (cc *recordingClientConn) ReportError(error) {}
+
+// TestResolverUpdatesAfterSnapshot checks that a new watch stream replaces
+// the addresses once its snapshot is complete, even when it is empty.
+func TestResolverUpdatesAfterSnapshot(t *testing.T) {
+	reg := NewServer()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	registrypb.RegisterServiceRegistryServer(s, reg.GRPC())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	cc := &recordingClientConn{states: make(chan []resolver.Address, 10)}
+	r := &registryResolver{cc: cc, name: "UserService", client: registrypb.NewServiceRegistryClient(conn)}
+	follow := func() (stop func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.follow(ctx)
+			close(done)
+		}()
+		return func() {
+			cancel()
+			<-done
+		}
+	}
+	expectAddresses := func(want int) {
+		t.Helper()
+		select {
+		case addrs := <-cc.states:
+			if len(addrs) != want {
+				t.Fatalf("addresses = %v, want %d", addrs, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no update")
+		}
+	}
+
+	second := testRegistration
+	second.InstanceID, second.Port = "instance-2", 8081
+	reg.register(testRegistration, nil, time.Now())
+	reg.register(second, nil, time.Now())
+	stop := follow()
+	// One update with the whole snapshot, not one per instance
+	expectAddresses(2)
+	stop()
+
+	// The instances went away while the stream was down
+	reg.deregister(testRegistration, nil)
+	reg.deregister(second, nil)
+	stop = follow()
+	expectAddresses(0)
+	stop()
+}
@@ -0,0 +1,442 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Instance is a registration as the registry holds it.
+type Instance struct {
+	// Key identifies the instance: its InstanceID, or name@host:port for
+	// registrations without one.
+	Key string `json:"key"`
+	Registration
+	Health     Health    `json:"health"`
+	Registered time.Time `json:"registered"`
+	LastSeen   time.Time `json:"last_seen"`
+	// Expires is when the lease runs out unless renewed.
+	Expires time.Time `json:"expires"`
+
+	// owner is the WebSocket connection that registered the instance, which
+	// alone may change it while it is open. nil when registered by POST or
+	// once the connection closed.
+	owner *websocket.Conn
+}
+
+// Event types delivered to subscribers.
+const (
+	EventAdded   = "added"
+	EventUpdated = "updated"
+	EventRemoved = "removed"
+)
+
+// Event is a change in membership.
+type Event struct {
+	Type     string   `json:"type"`
+	Instance Instance `json:"instance"`
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before it
+// is disconnected.
+const subscriberBuffer = 64
+
+// Server is a service registry. Services register at /register with the
+// protocol Client speaks over WebSocket, or by POSTing each message as
+// HTTPRegistrar does; /services lists them as JSON,
+// and /watch streams membership events over WebSocket. GRPC exposes the same
+// over gRPC.
+//
+// There is no authentication: any client can register an instance under any
+// name, and change any instance not held by an open WebSocket connection.
+// Run it on a trusted network only.
+type Server struct {
+	// DefaultTTL is the lease of registrations that do not carry one, such
+	// as those from clients predating leases, which re-register every 30
+	// seconds.
+	DefaultTTL time.Duration
+	// SweepInterval is how often expired leases are removed.
+	SweepInterval time.Duration
+
+	upgrader websocket.Upgrader
+
+	mu        sync.Mutex
+	instances map[string]*Instance
+	subs      map[chan Event]string
+}
+
+// NewServer returns an empty registry.
+func NewServer() *Server {
+	return &Server{
+		DefaultTTL:    90 * time.Second,
+		SweepInterval: time.Second,
+		instances:     map[string]*Instance{},
+		subs:          map[chan Event]string{},
+	}
+}
+
+// Handler serves /register, /services and /watch.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", s.serveRegister)
+	mux.HandleFunc("/services", s.serveServices)
+	mux.HandleFunc("/watch", s.serveWatch)
+	return mux
+}
+
+// Run removes expired registrations every SweepInterval until ctx is done.
+func (s *Server) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.expire(now)
+		}
+	}
+}
+
+// Instances returns the instances of the named service, or of every service
+// when name is empty, sorted by name and key.
+func (s *Server) Instances(name string) []Instance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.instancesLocked(name)
+}
+
+func (s *Server) instancesLocked(name string) []Instance {
+	var list []Instance
+	for _, inst := range s.instances {
+		if name == "" || inst.Name == name {
+			list = append(list, *inst)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].Key < list[j].Key
+	})
+	return list
+}
+
+// Subscribe returns the current instances of the named service (all when
+// name is empty) and a channel of subsequent changes. The channel is closed
+// by cancel, or by the server if the subscriber falls behind.
+func (s *Server) Subscribe(name string) (snapshot []Instance, events <-chan Event, cancel func()) {
+	ch := make(chan Event, subscriberBuffer)
+	// Both under one lock, so no change is missed or seen twice
+	s.mu.Lock()
+	s.subs[ch] = name
+	snapshot = s.instancesLocked(name)
+	s.mu.Unlock()
+
+	cancel = func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subs[ch]; ok {
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+	return snapshot, ch, cancel
+}
+
+// publish sends e to the subscribers. s.mu must be held.
+func (s *Server) publish(e Event) {
+	for ch, name := range s.subs {
+		if name != "" && name != e.Instance.Name {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+			log.Printf("Registry: disconnecting a subscriber %d events behind", subscriberBuffer)
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// errUnknownInstance and errNotOwner are why a message about an existing
+// instance was refused.
+var (
+	errUnknownInstance = errors.New("unknown instance")
+	errNotOwner        = errors.New("instance is registered on another connection")
+)
+
+// mayChange reports whether a message that came over owner (nil for POST)
+// may change inst.
+func (inst *Instance) mayChange(owner *websocket.Conn) bool {
+	return inst.owner == nil || inst.owner == owner
+}
+
+func instanceKey(r Registration) string {
+	if r.InstanceID != "" {
+		return r.InstanceID
+	}
+	return fmt.Sprintf("%s@%s:%d", r.Name, r.Host, r.Port)
+}
+
+func (s *Server) ttl(r Registration) time.Duration {
+	if r.TTLSeconds > 0 {
+		return time.Duration(r.TTLSeconds) * time.Second
+	}
+	return s.DefaultTTL
+}
+
+// register adds or replaces the instance r describes, on behalf of owner.
+func (s *Server) register(r Registration, owner *websocket.Conn, now time.Time) (string, error) {
+	if r.Name == "" || r.Host == "" || r.Port < 1 || r.Port > 65535 {
+		return "", fmt.Errorf("registration needs a name, host and port")
+	}
+	key := instanceKey(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst, existed := s.instances[key]
+	if existed && !inst.mayChange(owner) {
+		return "", fmt.Errorf("%s: %w", key, errNotOwner)
+	}
+	if !existed {
+		inst = &Instance{Key: key, Registered: now}
+		s.instances[key] = inst
+	}
+	inst.owner = owner
+	changed := !existed || !registrationEqual(inst.Registration, r)
+	inst.Registration = r
+	inst.LastSeen = now
+	inst.Expires = now.Add(s.ttl(r))
+
+	switch {
+	case !existed:
+		log.Printf("Registry: registered %s", key)
+		s.publish(Event{Type: EventAdded, Instance: *inst})
+	case changed:
+		s.publish(Event{Type: EventUpdated, Instance: *inst})
+	}
+	return key, nil
+}
+
+// heartbeat renews the lease of the instance r identifies, on behalf of
+// owner.
+func (s *Server) heartbeat(r Registration, h *Health, owner *websocket.Conn, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst, ok := s.instances[instanceKey(r)]
+	if !ok {
+		return errUnknownInstance
+	}
+	if !inst.mayChange(owner) {
+		return errNotOwner
+	}
+	inst.LastSeen = now
+	inst.Expires = now.Add(s.ttl(inst.Registration))
+	if h != nil && !healthEqual(inst.Health, *h) {
+		inst.Health = *h
+		s.publish(Event{Type: EventUpdated, Instance: *inst})
+	}
+	return nil
+}
+
+// deregister removes the instance r identifies, on behalf of owner.
+func (s *Server) deregister(r Registration, owner *websocket.Conn) error {
+	key := instanceKey(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst, ok := s.instances[key]
+	if !ok {
+		return errUnknownInstance
+	}
+	if !inst.mayChange(owner) {
+		return errNotOwner
+	}
+	delete(s.instances, key)
+	log.Printf("Registry: deregistered %s", key)
+	s.publish(Event{Type: EventRemoved, Instance: *inst})
+	return nil
+}
+
+// release gives up owner's hold on its instances when the connection closes,
+// so the service can register them again over a new one.
+func (s *Server) release(owner *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, inst := range s.instances {
+		if inst.owner == owner {
+			inst.owner = nil
+		}
+	}
+}
+
+// expire removes the instances whose lease ran out before now.
+func (s *Server) expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, inst := range s.instances {
+		if now.After(inst.Expires) {
+			delete(s.instances, key)
+			log.Printf("Registry: lease of %s expired", key)
+			s.publish(Event{Type: EventRemoved, Instance: *inst})
+		}
+	}
+}
+
+func registrationEqual(a, b Registration) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
+
+func healthEqual(a, b Health) bool {
+	if a.State != b.State || len(a.Unhealthy) != len(b.Unhealthy) {
+		return false
+	}
+	for i := range a.Unhealthy {
+		if a.Unhealthy[i] != b.Unhealthy[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// serveRegister speaks the registration protocol on one connection. The
+// instances registered over it can only be changed over it while it is open.
+// A connection that closes without deregistering leaves its instances to
+// expire with their lease.
+func (s *Server) serveRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		s.servePost(w, r)
+		return
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	defer s.release(conn)
+
+	for {
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var m Message
+		if err := json.Unmarshal(b, &m); err != nil {
+			conn.WriteJSON(Message{Op: OpError, Message: "invalid JSON: " + err.Error()})
+			continue
+		}
+		if err := conn.WriteJSON(s.handle(m, conn, time.Now())); err != nil {
+			return
+		}
+	}
+}
+
+// servePost applies one message POSTed as JSON and writes the reply.
+func (s *Server) servePost(w http.ResponseWriter, r *http.Request) {
+	reply := Message{Op: OpError}
+	var m Message
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		reply.Message = "invalid JSON: " + err.Error()
+	} else {
+		reply = s.handle(m, nil, time.Now())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if reply.Op == OpError {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(reply)
+}
+
+// handle applies one message from a service and returns the reply. owner is
+// the connection it came over, or nil for POST.
+func (s *Server) handle(m Message, owner *websocket.Conn, now time.Time) Message {
+	switch m.Op {
+	case OpRegister, "":
+		// Registrations without an op come from clients predating ops
+		key, err := s.register(m.Registration, owner, now)
+		if err != nil {
+			return Message{Op: OpError, Message: err.Error()}
+		}
+		return Message{Op: OpAck, Message: "registered " + key}
+	case OpHeartbeat:
+		switch err := s.heartbeat(m.Registration, m.Health, owner, now); {
+		case errors.Is(err, errUnknownInstance):
+			return Message{Op: OpReregister, Message: "unknown instance " + instanceKey(m.Registration)}
+		case err != nil:
+			return Message{Op: OpError, Message: instanceKey(m.Registration) + ": " + err.Error()}
+		}
+		return Message{Op: OpAck}
+	case OpDeregister:
+		if err := s.deregister(m.Registration, owner); err != nil {
+			return Message{Op: OpError, Message: instanceKey(m.Registration) + ": " + err.Error()}
+		}
+		return Message{Op: OpAck, Message: "deregistered " + instanceKey(m.Registration)}
+	default:
+		return Message{Op: OpError, Message: fmt.Sprintf("unknown op %q", m.Op)}
+	}
+}
+
+// serveServices lists the instances as JSON, optionally filtered by ?name=.
+func (s *Server) serveServices(w http.ResponseWriter, r *http.Request) {
+	list := s.Instances(r.URL.Query().Get("name"))
+	if list == nil {
+		list = []Instance{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// serveWatch streams membership events as JSON over WebSocket, starting with
+// an added event per current instance. ?name= filters by service.
+func (s *Server) serveWatch(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	snapshot, events, cancel := s.Subscribe(r.URL.Query().Get("name"))
+	defer cancel()
+
+	// Reading is only needed to notice the client going away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, inst := range snapshot {
+		if err := conn.WriteJSON(Event{Type: EventAdded, Instance: inst}); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case <-closed:
+			return
+		case e, ok := <-events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too far behind"), time.Now().Add(time.Second))
+				return
+			}
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/registrypb"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var testRegistration = Registration{
+	Name:       "UserService",
+	Host:       "10.0.0.1",
+	Port:       8080,
+	Type:       "gRPC",
+	InstanceID: "instance-1",
+	TTLSeconds: 30,
+	Services:   []Service{{Name: "UserService", Methods: []Method{{Name: "GetUser"}}}},
+}
+
+// startRegistry serves a registry over HTTP and returns its base URL.
+func startRegistry(t *testing.T) (*Server, string) {
+	t.Helper()
+	reg := NewServer()
+	srv := httptest.NewServer(reg.Handler())
+	t.Cleanup(srv.Close)
+	return reg, srv.URL
+}
+
+func dialWebSocket(t *testing.T, baseURL, path string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(baseURL, "http")+path, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", path, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// exchange sends m and returns the registry's reply.
+func exchange(t *testing.T, conn *websocket.Conn, m interface{}) Message {
+	t.Helper()
+	if err := conn.WriteJSON(m); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	var reply Message
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return reply
+}
+
+func listServices(t *testing.T, baseURL string) []Instance {
+	t.Helper()
+	resp, err := http.Get(baseURL + "/services")
+	if err != nil {
+		t.Fatalf("GET /services: %v", err)
+	}
+	defer resp.Body.Close()
+	var list []Instance
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("decoding /services: %v", err)
+	}
+	return list
+}
+
+func TestRegisterHeartbeatDeregister(t *testing.T) {
+	_, url := startRegistry(t)
+	conn := dialWebSocket(t, url, "/register")
+
+	if reply := exchange(t, conn, Message{Op: OpRegister, Registration: testRegistration}); reply.Op != OpAck {
+		t.Fatalf("register reply = %+v, want ack", reply)
+	}
+	list := listServices(t, url)
+	if len(list) != 1 || list[0].Key != "instance-1" || list[0].Port != 8080 || len(list[0].Services) != 1 {
+		t.Fatalf("/services = %+v, want the registered instance", list)
+	}
+
+	hb := Message{Op: OpHeartbeat, Registration: testRegistration.identity(), Health: &Health{State: "DEGRADED", Unhealthy: []string{"metrics"}}}
+	if reply := exchange(t, conn, hb); reply.Op != OpAck {
+		t.Fatalf("heartbeat reply = %+v, want ack", reply)
+	}
+	if got := listServices(t, url)[0].Health.State; got != "DEGRADED" {
+		t.Errorf("state after heartbeat = %q, want DEGRADED", got)
+	}
+
+	if reply := exchange(t, conn, Message{Op: OpDeregister, Registration: testRegistration.identity()}); reply.Op != OpAck {
+		t.Fatalf("deregister reply = %+v, want ack", reply)
+	}
+	if list := listServices(t, url); len(list) != 0 {
+		t.Fatalf("/services after deregister = %+v, want none", list)
+	}
+}
+
+func TestLegacyRegistration(t *testing.T) {
+	reg, url := startRegistry(t)
+	conn := dialWebSocket(t, url, "/register")
+
+	// The original payload: no op, no instance ID, no lease
+	legacy := map[string]interface{}{"name": "Student-Info gRPC Service Cloud", "host": "localhost", "port": 8080, "type": "gRPC"}
+	if reply := exchange(t, conn, legacy); reply.Op != OpAck {
+		t.Fatalf("legacy register reply = %+v, want ack", reply)
+	}
+	list := reg.Instances("")
+	if len(list) != 1 || list[0].Key != "Student-Info gRPC Service Cloud@localhost:8080" {
+		t.Fatalf("instances = %+v, want one keyed by name@host:port", list)
+	}
+	if ttl := list[0].Expires.Sub(list[0].LastSeen); ttl != reg.DefaultTTL {
+		t.Errorf("lease = %v, want the default %v", ttl, reg.DefaultTTL)
+	}
+}
+
+func TestHeartbeatFromUnknownInstanceAsksToReregister(t *testing.T) {
+	_, url := startRegistry(t)
+	conn := dialWebSocket(t, url, "/register")
+
+	reply := exchange(t, conn, Message{Op: OpHeartbeat, Registration: testRegistration.identity()})
+	if reply.Op != OpReregister {
+		t.Fatalf("reply = %+v, want reregister", reply)
+	}
+}
+
+func TestInstancesBelongToTheirConnection(t *testing.T) {
+	_, url := startRegistry(t)
+	owner := dialWebSocket(t, url, "/register")
+	other := dialWebSocket(t, url, "/register")
+
+	if reply := exchange(t, owner, Message{Op: OpRegister, Registration: testRegistration}); reply.Op != OpAck {
+		t.Fatalf("register reply = %+v, want ack", reply)
+	}
+	for _, m := range []Message{
+		{Op: OpRegister, Registration: testRegistration},
+		{Op: OpHeartbeat, Registration: testRegistration.identity(), Health: &Health{State: "NOT_SERVING"}},
+		{Op: OpDeregister, Registration: testRegistration.identity()},
+	} {
+		if reply := exchange(t, other, m); reply.Op != OpError {
+			t.Errorf("%s from another connection: reply = %+v, want error", m.Op, reply)
+		}
+		b, _ := json.Marshal(m)
+		resp, err := http.Post(url+"/register", "application/json", strings.NewReader(string(b)))
+		if err != nil {
+			t.Fatalf("POST /register: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s over POST: status = %d, want %d", m.Op, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+	if list := listServices(t, url); len(list) != 1 || list[0].Health.State != "" {
+		t.Fatalf("/services = %+v, want the instance untouched", list)
+	}
+
+	// Once the owner is gone, the service may register it again elsewhere
+	owner.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		reply := exchange(t, other, Message{Op: OpRegister, Registration: testRegistration})
+		if reply.Op == OpAck {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("register after the owner closed: reply = %+v, want ack", reply)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestInvalidMessages(t *testing.T) {
+	_, url := startRegistry(t)
+	conn := dialWebSocket(t, url, "/register")
+
+	for _, m := range []interface{}{
+		Message{Op: "bogus"},
+		Message{Op: OpRegister, Registration: Registration{Name: "no-host"}},
+		Message{Op: OpDeregister, Registration: testRegistration.identity()},
+	} {
+		if reply := exchange(t, conn, m); reply.Op != OpError {
+			t.Errorf("reply to %+v = %+v, want error", m, reply)
+		}
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	reg := NewServer()
+	events := subscribe(t, reg, "")
+	now := time.Now()
+
+	if _, err := reg.register(testRegistration, nil, now); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	expectEvent(t, events, EventAdded)
+
+	// A heartbeat renews the lease
+	reg.heartbeat(testRegistration.identity(), nil, nil, now.Add(20*time.Second))
+	reg.expire(now.Add(40 * time.Second))
+	if len(reg.Instances("")) != 1 {
+		t.Fatalf("instance expired although its lease was renewed")
+	}
+
+	reg.expire(now.Add(51 * time.Second))
+	if len(reg.Instances("")) != 0 {
+		t.Fatalf("instance outlived its lease")
+	}
+	expectEvent(t, events, EventRemoved)
+}
+
+func subscribe(t *testing.T, reg *Server, name string) <-chan Event {
+	t.Helper()
+	_, events, cancel := reg.Subscribe(name)
+	t.Cleanup(cancel)
+	return events
+}
+
+// expectEvent returns the next event, failing unless it is one of want.
+func expectEvent(t *testing.T, events <-chan Event, want ...string) Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		for _, w := range want {
+			if e.Type == w {
+				return e
+			}
+		}
+		t.Fatalf("event %s, want %s", e.Type, strings.Join(want, " or "))
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %s event", strings.Join(want, " or "))
+		return Event{}
+	}
+}
+
+func TestWatchOverWebSocket(t *testing.T) {
+	reg, url := startRegistry(t)
+	reg.register(testRegistration, nil, time.Now())
+
+	watch := dialWebSocket(t, url, "/watch?name=UserService")
+	readEvent := func() Event {
+		var e Event
+		watch.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := watch.ReadJSON(&e); err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		return e
+	}
+	if e := readEvent(); e.Type != EventAdded || e.Instance.Key != "instance-1" {
+		t.Fatalf("first event = %+v, want the existing instance added", e)
+	}
+
+	// Other services are filtered out
+	other := testRegistration
+	other.Name, other.InstanceID = "StatusService", "instance-2"
+	reg.register(other, nil, time.Now())
+	reg.deregister(testRegistration, nil)
+	if e := readEvent(); e.Type != EventRemoved || e.Instance.Key != "instance-1" {
+		t.Fatalf("event = %+v, want instance-1 removed", e)
+	}
+}
+
+func TestGRPCListAndWatch(t *testing.T) {
+	reg := NewServer()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	registrypb.RegisterServiceRegistryServer(s, reg.GRPC())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := registrypb.NewServiceRegistryClient(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Watch(ctx, &registrypb.WatchRequest{})
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	// Wait until the subscription is in place before changing membership
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		reg.mu.Lock()
+		n := len(reg.subs)
+		reg.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Watch did not subscribe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	reg.register(testRegistration, nil, time.Now())
+	reg.heartbeat(testRegistration.identity(), &Health{State: "SERVING"}, nil, time.Now())
+	for _, want := range []registrypb.MembershipEvent_Type{registrypb.MembershipEvent_ADDED, registrypb.MembershipEvent_UPDATED} {
+		e, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if e.Type != want || e.Instance.GetKey() != "instance-1" {
+			t.Fatalf("event = %v %s, want %v instance-1", e.Type, e.Instance.GetKey(), want)
+		}
+	}
+
+	list, err := client.ListServices(ctx, &registrypb.ListServicesRequest{Name: "UserService"})
+	if err != nil {
+		t.Fatalf("ListServices: %v", err)
+	}
+	if len(list.Instances) != 1 || list.Instances[0].State != "SERVING" || list.Instances[0].Services[0].Methods[0].Name != "GetUser" {
+		t.Fatalf("ListServices = %v, want instance-1 with its catalog", list)
+	}
+}
+
+// TestClientAgainstServer runs the registration client against the reference
+// server: it registers on connect and deregisters when stopped.
+func TestClientAgainstServer(t *testing.T) {
+	reg, url := startRegistry(t)
+	events := subscribe(t, reg, "")
+
+	client := NewClient("ws"+strings.TrimPrefix(url, "http")+"/register", testRegistration)
+	client.HeartbeatInterval = 20 * time.Millisecond
+	client.Health = func() Health { return Health{State: "SERVING"} }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		client.Run(ctx)
+		close(done)
+	}()
+
+	expectEvent(t, events, EventAdded)
+	if e := expectEvent(t, events, EventUpdated); e.Instance.Health.State != "SERVING" {
+		t.Errorf("heartbeat state = %q, want SERVING", e.Instance.Health.State)
+	}
+
+	cancel()
+	<-done
+	expectEvent(t, events, EventRemoved)
+}
+
+// TestHTTPRegistrarAgainstServer does the same over POST /register.
+func TestHTTPRegistrarAgainstServer(t *testing.T) {
+	reg, url := startRegistry(t)
+	events := subscribe(t, reg, "")
+
+	registrar := NewHTTPRegistrar(url+"/register", testRegistration)
+	registrar.HeartbeatInterval = 20 * time.Millisecond
+	registrar.Health = func() Health { return Health{State: "SERVING"} }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		registrar.Run(ctx)
+		close(done)
+	}()
+
+	expectEvent(t, events, EventAdded)
+	if e := expectEvent(t, events, EventUpdated); e.Instance.Health.State != "SERVING" {
+		t.Errorf("heartbeat state = %q, want SERVING", e.Instance.Health.State)
+	}
+
+	// A registry that lost the instance asks for it again
+	reg.deregister(testRegistration, nil)
+	expectEvent(t, events, EventRemoved)
+	expectEvent(t, events, EventAdded)
+
+	cancel()
+	<-done
+	for e := expectEvent(t, events, EventUpdated, EventRemoved); e.Type != EventRemoved; {
+		e = expectEvent(t, events, EventUpdated, EventRemoved)
+	}
+}
+
+// TestClientNoticesSilentRegistry checks that a registry that stops answering,
+// while the connection stays open, is noticed within a few heartbeats.
+func TestClientNoticesSilentRegistry(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	conns := make(chan *websocket.Conn, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		// Never read, so neither replies nor pongs are sent
+		conns <- conn
+	}))
+	t.Cleanup(func() {
+		srv.Close()
+		for len(conns) > 0 {
+			(<-conns).Close()
+		}
+	})
+
+	failed := make(chan error, 1)
+	client := NewClient("ws"+strings.TrimPrefix(srv.URL, "http"), testRegistration)
+	client.HeartbeatInterval = 20 * time.Millisecond
+	client.MinBackoff = time.Hour
+	client.OnStatus = func(err error) {
+		if err != nil {
+			select {
+			case failed <- err:
+			default:
+			}
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go client.Run(ctx)
+
+	select {
+	case err := <-failed:
+		if !strings.Contains(err.Error(), "timeout") {
+			t.Errorf("error = %v, want a read timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("silent registry not noticed")
+	}
+}
@@ -0,0 +1,175 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: registry.proto
+
+package registrypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ServiceRegistryClient is the client API for ServiceRegistry service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ServiceRegistryClient interface {
+	ListServices(ctx context.Context, in *ListServicesRequest, opts ...grpc.CallOption) (*ListServicesResponse, error)
+	// Watch sends an ADDED event for every current instance, then an event for
+	// every change. A subscriber that falls behind is disconnected and should
+	// watch again.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (ServiceRegistry_WatchClient, error)
+}
+
+type serviceRegistryClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewServiceRegistryClient(cc grpc.ClientConnInterface) ServiceRegistryClient {
+	return &serviceRegistryClient{cc}
+}
+
+func (c *serviceRegistryClient) ListServices(ctx context.Context, in *ListServicesRequest, opts ...grpc.CallOption) (*ListServicesResponse, error) {
+	out := new(ListServicesResponse)
+	err := c.cc.Invoke(ctx, "/ServiceRegistry/ListServices", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceRegistryClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (ServiceRegistry_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ServiceRegistry_ServiceDesc.Streams[0], "/ServiceRegistry/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceRegistryWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ServiceRegistry_WatchClient interface {
+	Recv() (*MembershipEvent, error)
+	grpc.ClientStream
+}
+
+type serviceRegistryWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceRegistryWatchClient) Recv() (*MembershipEvent, error) {
+	m := new(MembershipEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ServiceRegistryServer is the server API for ServiceRegistry service.
+// All implementations must embed UnimplementedServiceRegistryServer
+// for forward compatibility
+type ServiceRegistryServer interface {
+	ListServices(context.Context, *ListServicesRequest) (*ListServicesResponse, error)
+	// Watch sends an ADDED event for every current instance, then an event for
+	// every change. A subscriber that falls behind is disconnected and should
+	// watch again.
+	Watch(*WatchRequest, ServiceRegistry_WatchServer) error
+	mustEmbedUnimplementedServiceRegistryServer()
+}
+
+// UnimplementedServiceRegistryServer must be embedded to have forward compatible implementations.
+type UnimplementedServiceRegistryServer struct {
+}
+
+func (UnimplementedServiceRegistryServer) ListServices(context.Context, *ListServicesRequest) (*ListServicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListServices not implemented")
+}
+func (UnimplementedServiceRegistryServer) Watch(*WatchRequest, ServiceRegistry_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedServiceRegistryServer) mustEmbedUnimplementedServiceRegistryServer() {}
+
+// UnsafeServiceRegistryServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ServiceRegistryServer will
+// result in compilation errors.
+type UnsafeServiceRegistryServer interface {
+	mustEmbedUnimplementedServiceRegistryServer()
+}
+
+func RegisterServiceRegistryServer(s grpc.ServiceRegistrar, srv ServiceRegistryServer) {
+	s.RegisterService(&ServiceRegistry_ServiceDesc, srv)
+}
+
+func _ServiceRegistry_ListServices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListServicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceRegistryServer).ListServices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ServiceRegistry/ListServices",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceRegistryServer).ListServices(ctx, req.(*ListServicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ServiceRegistry_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceRegistryServer).Watch(m, &serviceRegistryWatchServer{stream})
+}
+
+type ServiceRegistry_WatchServer interface {
+	Send(*MembershipEvent) error
+	grpc.ServerStream
+}
+
+type serviceRegistryWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceRegistryWatchServer) Send(m *MembershipEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// ServiceRegistry_ServiceDesc is the grpc.ServiceDesc for ServiceRegistry service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ServiceRegistry_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ServiceRegistry",
+	HandlerType: (*ServiceRegistryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListServices",
+			Handler:    _ServiceRegistry_ListServices_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _ServiceRegistry_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "registry.proto",
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/config"
+	"github.com/Ling-Qingran/gRPC-Observability/metrics"
+	"github.com/Ling-Qingran/gRPC-Observability/tracing"
+)
+
+// configPollInterval is how often the configuration file is checked for
+// changes. Polling, unlike inotify, also sees Kubernetes ConfigMap updates,
+// which swap a symlink rather than writing the file.
+const configPollInterval = 5 * time.Second
+
+// reloader re-reads the configuration on SIGHUP or when its file changes and
+// applies the parts that can change while running. A configuration that fails
+// to load or validate is rejected and the running one is kept.
+type reloader struct {
+	args []string
+
+	mu      sync.Mutex
+	current config.Config
+}
+
+func newReloader(args []string, cfg config.Config) *reloader {
+	return &reloader{args: args, current: cfg}
+}
+
+// applyReloadable applies the settings that can change while running.
+func applyReloadable(cfg config.Config) error {
+	level, err := cfg.Level()
+	if err != nil {
+		return err
+	}
+	proxies, err := parseProxies(cfg.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	if err := metrics.Configure(cfg.Metrics); err != nil {
+		return err
+	}
+	trustedProxies.Store(&proxies)
+	tracing.SetSampleRate(cfg.Tracing.SampleRate)
+	accessLevel.Set(level)
+	accessLog.Store(newAccessLogConfig(cfg.AccessLog))
+	return nil
+}
+
+// reload loads the configuration again and applies it. trigger names what
+// caused the reload and is recorded with the outcome in
+// metrics.ReloadMeasurement.
+func (r *reloader) reload(trigger string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, _, err := config.Load(r.args)
+	if err == nil {
+		err = applyReloadable(next)
+	}
+	if err != nil {
+		log.Printf("Configuration reload (%s) rejected, keeping the running configuration: %v", trigger, err)
+		metrics.WriteReload(
+			map[string]string{"trigger": trigger, "outcome": "rejected"},
+			map[string]interface{}{"error": true, "restart_required": 0},
+		)
+		return err
+	}
+
+	restart := r.current.RestartRequired(next)
+	if len(restart) > 0 {
+		log.Printf("Configuration reloaded (%s); changes to %s take effect after a restart", trigger, strings.Join(restart, ", "))
+	} else {
+		log.Printf("Configuration reloaded (%s)", trigger)
+	}
+	metrics.WriteReload(
+		map[string]string{"trigger": trigger, "outcome": "applied"},
+		map[string]interface{}{"error": false, "restart_required": len(restart)},
+	)
+	// Sections needing a restart keep their running values, so a later
+	// reload still reports them
+	r.current = r.current.WithReloadable(next)
+	return nil
+}
+
+// watchSignals reloads on every SIGHUP.
+func (r *reloader) watchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		r.reload("sighup")
+	}
+}
+
+// watchFile reloads when the modification time or size of path changes.
+func (r *reloader) watchFile(path string) {
+	last, _ := os.Stat(path)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		info, err := os.Stat(path)
+		if err != nil {
+			// A file being replaced can briefly be missing; try again next tick
+			continue
+		}
+		if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+		r.reload("file")
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Ling-Qingran/gRPC-Observability/config"
+)
+
+func TestReloadKeepsReportingRestartRequired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("port: 9001\nlog_level: info\n")
+	args := []string{"-config", path}
+	cfg, _, err := config.Load(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newReloader(args, cfg)
+	t.Cleanup(func() { applyReloadable(config.Default()) })
+
+	// The port needs a restart, the log level does not
+	write("port: 9002\nlog_level: debug\n")
+	if err := r.reload("test"); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if r.current.Port != 9001 || r.current.LogLevel != "debug" {
+		t.Errorf("after reload: port %d, log level %s, want the running 9001 and the applied debug", r.current.Port, r.current.LogLevel)
+	}
+
+	// A second reload still sees the port as pending, along with the
+	// advertised port that follows it
+	next, _, err := config.Load(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.current.RestartRequired(next); !reflect.DeepEqual(got, []string{"port", "service"}) {
+		t.Errorf("RestartRequired after a second load = %v, want [port service]", got)
+	}
+}
@@ -0,0 +1,131 @@
+// Package requestid assigns every call an ID that ties together its metric
+// point, log line and error. The ID is taken from the caller's x-request-id
+// metadata when present, generated otherwise, and echoed back to the caller in
+// the response header and trailer.
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// MetadataKey is the metadata key carrying the request ID in both directions.
+const MetadataKey = "x-request-id"
+
+// maxLength bounds IDs accepted from callers so a client cannot inflate every
+// log line and metric point.
+const maxLength = 128
+
+type contextKey struct{}
+
+// FromContext returns the request ID of the call in ctx, or "" if none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// UnaryServerInterceptor assigns the request ID, returns it in the response
+// metadata and attaches it to errors as a RequestInfo detail.
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	id := fromIncoming(ctx)
+	ctx = NewContext(ctx, id)
+	md := metadata.Pairs(MetadataKey, id)
+	grpc.SetHeader(ctx, md)
+	grpc.SetTrailer(ctx, md)
+
+	resp, err := handler(ctx, req)
+	return resp, withDetail(err, id)
+}
+
+// StreamServerInterceptor is the streaming counterpart of
+// UnaryServerInterceptor.
+func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	id := fromIncoming(ss.Context())
+	md := metadata.Pairs(MetadataKey, id)
+	ss.SetHeader(md)
+	ss.SetTrailer(md)
+
+	err := handler(srv, &serverStream{ServerStream: ss, ctx: NewContext(ss.Context(), id)})
+	return withDetail(err, id)
+}
+
+// UnaryClientInterceptor forwards the request ID in ctx to outgoing calls, so
+// calls made while serving a request share its ID. A call made outside any
+// request gets a new ID, which later interceptors find in their ctx, so the
+// client's metric point can be joined with the server's.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+}
+
+// StreamClientInterceptor is the streaming counterpart of
+// UnaryClientInterceptor.
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(outgoing(ctx), desc, cc, method, opts...)
+}
+
+func outgoing(ctx context.Context) context.Context {
+	if id := FromContext(ctx); id != "" {
+		return metadata.AppendToOutgoingContext(ctx, MetadataKey, id)
+	}
+	// An ID the caller put in the metadata itself is kept
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if v := md.Get(MetadataKey); len(v) > 0 && valid(v[0]) {
+			return NewContext(ctx, v[0])
+		}
+	}
+	id := uuid.NewString()
+	return metadata.AppendToOutgoingContext(NewContext(ctx, id), MetadataKey, id)
+}
+
+// fromIncoming returns the caller's request ID if it is usable, or a new one.
+func fromIncoming(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(MetadataKey); len(v) > 0 && valid(v[0]) {
+			return v[0]
+		}
+	}
+	return uuid.NewString()
+}
+
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// withDetail attaches id to err as a RequestInfo detail, keeping its code and
+// message.
+func withDetail(err error, id string) error {
+	if err == nil {
+		return nil
+	}
+	st := status.Convert(err)
+	withInfo, detailErr := st.WithDetails(&errdetails.RequestInfo{RequestId: id})
+	if detailErr != nil {
+		return err
+	}
+	return withInfo.Err()
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
@@ -0,0 +1,122 @@
+package requestid
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Ling-Qingran/gRPC-Observability/status"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// echoServer records the request ID each call was served with.
+type echoServer struct {
+	status.UnimplementedStatusServiceServer
+	seen chan string
+	err  error
+}
+
+func (s *echoServer) CheckStatus(ctx context.Context, _ *status.StatusRequest) (*status.StatusResponse, error) {
+	s.seen <- FromContext(ctx)
+	return &status.StatusResponse{}, s.err
+}
+
+// dial serves srv behind the server interceptor and returns a client with
+// the client interceptor, followed by one recording the ID in its ctx.
+func dial(t *testing.T, srv *echoServer, clientSeen chan<- string) status.StatusServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.UnaryInterceptor(UnaryServerInterceptor))
+	status.RegisterStatusServiceServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	record := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		clientSeen <- FromContext(ctx)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor, record),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return status.NewStatusServiceClient(conn)
+}
+
+func TestClientPropagation(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		want string // "" means any generated ID
+	}{
+		{"generated", context.Background(), ""},
+		{"from context", NewContext(context.Background(), "req-1"), "req-1"},
+		{"from outgoing metadata", metadata.AppendToOutgoingContext(context.Background(), MetadataKey, "req-2"), "req-2"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := &echoServer{seen: make(chan string, 1)}
+			clientSeen := make(chan string, 1)
+			client := dial(t, srv, clientSeen)
+
+			var header, trailer metadata.MD
+			if _, err := client.CheckStatus(tc.ctx, &status.StatusRequest{}, grpc.Header(&header), grpc.Trailer(&trailer)); err != nil {
+				t.Fatalf("CheckStatus: %v", err)
+			}
+			clientID, serverID := <-clientSeen, <-srv.seen
+			if clientID == "" || clientID != serverID {
+				t.Errorf("client ID %q, server ID %q, want the same non-empty ID", clientID, serverID)
+			}
+			if tc.want != "" && serverID != tc.want {
+				t.Errorf("server ID %q, want %q", serverID, tc.want)
+			}
+			for name, md := range map[string]metadata.MD{"header": header, "trailer": trailer} {
+				if got := md.Get(MetadataKey); len(got) != 1 || got[0] != serverID {
+					t.Errorf("%s %s = %v, want [%s]", name, MetadataKey, got, serverID)
+				}
+			}
+		})
+	}
+}
+
+func TestServerReplacesInvalidID(t *testing.T) {
+	for _, bad := range []string{"has space", strings.Repeat("a", maxLength+1)} {
+		srv := &echoServer{seen: make(chan string, 1)}
+		client := dial(t, srv, make(chan string, 1))
+		ctx := metadata.AppendToOutgoingContext(context.Background(), MetadataKey, bad)
+		if _, err := client.CheckStatus(ctx, &status.StatusRequest{}); err != nil {
+			t.Fatalf("CheckStatus: %v", err)
+		}
+		if id := <-srv.seen; id == bad || !valid(id) {
+			t.Errorf("server kept invalid ID %q as %q", bad, id)
+		}
+	}
+}
+
+func TestErrorsCarryRequestInfo(t *testing.T) {
+	srv := &echoServer{seen: make(chan string, 1), err: grpcstatus.Error(codes.NotFound, "no such user")}
+	client := dial(t, srv, make(chan string, 1))
+
+	_, err := client.CheckStatus(NewContext(context.Background(), "req-3"), &status.StatusRequest{})
+	<-srv.seen
+	st := grpcstatus.Convert(err)
+	if st.Code() != codes.NotFound || st.Message() != "no such user" {
+		t.Errorf("error = %v, want the handler's NotFound", err)
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.RequestInfo); ok && info.RequestId == "req-3" {
+			return
+		}
+	}
+	t.Errorf("details %v lack RequestInfo for req-3", st.Details())
+}
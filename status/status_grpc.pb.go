@@ -1,7 +1,7 @@
 // Code generated by protoc-gen-go-grpc. DO NOT EDIT.
 // versions:
 // - protoc-gen-go-grpc v1.2.0
-// - protoc             v4.24.3
+// - protoc             (unknown)
 // source: status.proto
 
 package status
@@ -23,6 +23,10 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type StatusServiceClient interface {
 	CheckStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+	// WatchStatus sends the current status, then a new one whenever the state
+	// or the health of a dependency changes, and a heartbeat in between. The
+	// stream ends after the DRAINING status is sent.
+	WatchStatus(ctx context.Context, in *WatchStatusRequest, opts ...grpc.CallOption) (StatusService_WatchStatusClient, error)
 }
 
 type statusServiceClient struct {
@@ -42,11 +46,47 @@ func (c *statusServiceClient) CheckStatus(ctx context.Context, in *StatusRequest
 	return out, nil
 }
 
+func (c *statusServiceClient) WatchStatus(ctx context.Context, in *WatchStatusRequest, opts ...grpc.CallOption) (StatusService_WatchStatusClient, error) {
+	stream, err := c.cc.NewStream(ctx, &StatusService_ServiceDesc.Streams[0], "/StatusService/WatchStatus", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &statusServiceWatchStatusClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type StatusService_WatchStatusClient interface {
+	Recv() (*StatusResponse, error)
+	grpc.ClientStream
+}
+
+type statusServiceWatchStatusClient struct {
+	grpc.ClientStream
+}
+
+func (x *statusServiceWatchStatusClient) Recv() (*StatusResponse, error) {
+	m := new(StatusResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // StatusServiceServer is the server API for StatusService service.
 // All implementations must embed UnimplementedStatusServiceServer
 // for forward compatibility
 type StatusServiceServer interface {
 	CheckStatus(context.Context, *StatusRequest) (*StatusResponse, error)
+	// WatchStatus sends the current status, then a new one whenever the state
+	// or the health of a dependency changes, and a heartbeat in between. The
+	// stream ends after the DRAINING status is sent.
+	WatchStatus(*WatchStatusRequest, StatusService_WatchStatusServer) error
 	mustEmbedUnimplementedStatusServiceServer()
 }
 
@@ -57,6 +97,9 @@ type UnimplementedStatusServiceServer struct {
 func (UnimplementedStatusServiceServer) CheckStatus(context.Context, *StatusRequest) (*StatusResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CheckStatus not implemented")
 }
+func (UnimplementedStatusServiceServer) WatchStatus(*WatchStatusRequest, StatusService_WatchStatusServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchStatus not implemented")
+}
 func (UnimplementedStatusServiceServer) mustEmbedUnimplementedStatusServiceServer() {}
 
 // UnsafeStatusServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -88,6 +131,27 @@ func _StatusService_CheckStatus_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _StatusService_WatchStatus_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchStatusRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StatusServiceServer).WatchStatus(m, &statusServiceWatchStatusServer{stream})
+}
+
+type StatusService_WatchStatusServer interface {
+	Send(*StatusResponse) error
+	grpc.ServerStream
+}
+
+type statusServiceWatchStatusServer struct {
+	grpc.ServerStream
+}
+
+func (x *statusServiceWatchStatusServer) Send(m *StatusResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // StatusService_ServiceDesc is the grpc.ServiceDesc for StatusService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -100,6 +164,12 @@ var StatusService_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _StatusService_CheckStatus_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchStatus",
+			Handler:       _StatusService_WatchStatus_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "status.proto",
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/config"
+	"github.com/Ling-Qingran/gRPC-Observability/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// newTestStatusServer returns a status server over a prober with a required
+// storage dependency and an optional metrics one, neither checked yet.
+func newTestStatusServer() *statusServiceServer {
+	prober := newHealthProber(health.NewServer(), config.Health{},
+		dependency{name: "storage", services: []string{""}},
+		dependency{name: "metrics"},
+	)
+	return &statusServiceServer{health: prober}
+}
+
+func TestCheckStatus(t *testing.T) {
+	prevVersion, prevCommit := version, commit
+	version, commit = "v1.2.3", "abc123"
+	t.Cleanup(func() { version, commit = prevVersion, prevCommit })
+
+	s := newTestStatusServer()
+	resp, err := s.CheckStatus(context.Background(), &status.StatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.State != status.State_NOT_SERVING || resp.Status != "Down" {
+		t.Errorf("before the first check: %v %q, want NOT_SERVING Down", resp.State, resp.Status)
+	}
+	if resp.Version != "v1.2.3" || resp.Commit != "abc123" {
+		t.Errorf("version, commit = %q, %q, want v1.2.3, abc123", resp.Version, resp.Commit)
+	}
+	if !resp.StartTime.AsTime().Equal(startTime) || resp.Uptime.AsDuration() < 0 {
+		t.Errorf("start time %v, uptime %v, want the process start and a non-negative uptime", resp.StartTime.AsTime(), resp.Uptime.AsDuration())
+	}
+	for _, dep := range resp.Dependencies {
+		if dep.Healthy || dep.LastChecked != nil {
+			t.Errorf("dependency %s before the first check = %+v, want unhealthy and unchecked", dep.Name, dep)
+		}
+	}
+
+	s.health.report("storage", nil)
+	s.health.report("metrics", errors.New("connection refused"))
+	resp, _ = s.CheckStatus(context.Background(), &status.StatusRequest{})
+	if resp.State != status.State_DEGRADED || resp.Status != "Degraded" {
+		t.Errorf("with metrics failing: %v %q, want DEGRADED Degraded", resp.State, resp.Status)
+	}
+	if len(resp.Dependencies) != 2 {
+		t.Fatalf("dependencies = %v, want storage and metrics", resp.Dependencies)
+	}
+	storageDep, metricsDep := resp.Dependencies[0], resp.Dependencies[1]
+	if d := storageDep; d.Name != "storage" || !d.Healthy || !d.Required || d.LastChecked == nil || d.LastSuccess == nil || d.Error != "" {
+		t.Errorf("storage = %+v, want healthy, required, checked and successful", storageDep)
+	}
+	if d := metricsDep; d.Name != "metrics" || d.Healthy || d.Required || d.LastChecked == nil || d.LastSuccess != nil || d.Error != "connection refused" {
+		t.Errorf("metrics = %+v, want unhealthy, optional, never successful with its error", metricsDep)
+	}
+
+	s.health.report("storage", errors.New("quota exceeded"))
+	if resp, _ = s.CheckStatus(context.Background(), &status.StatusRequest{}); resp.State != status.State_NOT_SERVING {
+		t.Errorf("with storage failing: %v, want NOT_SERVING", resp.State)
+	}
+
+	s.drain()
+	if resp, _ = s.CheckStatus(context.Background(), &status.StatusRequest{}); resp.State != status.State_DRAINING || resp.Status != "Draining" {
+		t.Errorf("while draining: %v %q, want DRAINING Draining", resp.State, resp.Status)
+	}
+}
+
+func TestWatchStatus(t *testing.T) {
+	s := newTestStatusServer()
+	s.health.report("storage", nil)
+	s.health.report("metrics", nil)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	status.RegisterStatusServiceServer(srv, s)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := status.NewStatusServiceClient(conn).WatchStatus(ctx, &status.WatchStatusRequest{HeartbeatInterval: durationpb.New(time.Second)})
+	if err != nil {
+		t.Fatalf("WatchStatus: %v", err)
+	}
+	recv := func(wantState status.State, wantHeartbeat bool) {
+		t.Helper()
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if resp.State != wantState || resp.Heartbeat != wantHeartbeat {
+			t.Fatalf("got %v heartbeat=%v, want %v heartbeat=%v", resp.State, resp.Heartbeat, wantState, wantHeartbeat)
+		}
+	}
+
+	// The current status first, then every change as it happens
+	recv(status.State_SERVING, false)
+	start := time.Now()
+	s.health.report("metrics", errors.New("down"))
+	recv(status.State_DEGRADED, false)
+	if d := time.Since(start); d >= time.Second {
+		t.Errorf("change arrived after %v, want before the heartbeat", d)
+	}
+
+	// Nothing changes, so a heartbeat repeats the status
+	recv(status.State_DEGRADED, true)
+
+	// Draining is sent and ends the stream
+	s.drain()
+	recv(status.State_DRAINING, false)
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("after draining: %v, want the stream to end", err)
+	}
+}
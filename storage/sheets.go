@@ -0,0 +1,179 @@
+// Package storage wraps the Google Sheets API used to store users. Every
+// backend call is timed, retried when the failure is transient and recorded in
+// gRPCBackendMetrics, tagged with the gRPC method it was made for.
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/metrics"
+	"github.com/Ling-Qingran/gRPC-Observability/requestid"
+	"github.com/Ling-Qingran/gRPC-Observability/tracing"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+	"google.golang.org/grpc"
+)
+
+// Operation names used for the "operation" tag.
+const (
+	OpValuesGet    = "Values.Get"
+	OpValuesUpdate = "Values.Update"
+	OpValuesAppend = "Values.Append"
+	OpBatchUpdate  = "BatchUpdate"
+)
+
+// Sheets is a spreadsheet holding one user per row.
+type Sheets struct {
+	srv           *sheets.Service
+	spreadsheetID string
+
+	// MaxAttempts bounds how many times a call is tried, including the first.
+	MaxAttempts int
+	// Backoff is the delay before the first retry; it doubles on every retry.
+	Backoff time.Duration
+}
+
+// NewSheets returns a Sheets backed by the given spreadsheet.
+func NewSheets(srv *sheets.Service, spreadsheetID string) *Sheets {
+	return &Sheets{
+		srv:           srv,
+		spreadsheetID: spreadsheetID,
+		MaxAttempts:   3,
+		Backoff:       100 * time.Millisecond,
+	}
+}
+
+// Get reads the values in readRange.
+func (s *Sheets) Get(ctx context.Context, readRange string) (*sheets.ValueRange, error) {
+	var resp *sheets.ValueRange
+	err := s.do(ctx, OpValuesGet, true, func() (err error) {
+		resp, err = s.srv.Spreadsheets.Values.Get(s.spreadsheetID, readRange).Context(ctx).Do()
+		return err
+	})
+	return resp, err
+}
+
+// Update overwrites the values in updateRange.
+func (s *Sheets) Update(ctx context.Context, updateRange string, values *sheets.ValueRange) error {
+	return s.do(ctx, OpValuesUpdate, true, func() error {
+		_, err := s.srv.Spreadsheets.Values.Update(s.spreadsheetID, updateRange, values).ValueInputOption("RAW").Context(ctx).Do()
+		return err
+	})
+}
+
+// Append adds values after the last row of writeRange.
+func (s *Sheets) Append(ctx context.Context, writeRange string, values *sheets.ValueRange) error {
+	return s.do(ctx, OpValuesAppend, false, func() error {
+		_, err := s.srv.Spreadsheets.Values.Append(s.spreadsheetID, writeRange, values).ValueInputOption("RAW").Context(ctx).Do()
+		return err
+	})
+}
+
+// Ping checks that the spreadsheet can be read by fetching only its ID. It is
+// meant for health probes and is neither retried nor recorded, so probes do not
+// show up as backend traffic.
+func (s *Sheets) Ping(ctx context.Context) error {
+	_, err := s.srv.Spreadsheets.Get(s.spreadsheetID).Fields("spreadsheetId").Context(ctx).Do()
+	return err
+}
+
+// BatchUpdate applies structural changes such as row deletions.
+func (s *Sheets) BatchUpdate(ctx context.Context, req *sheets.BatchUpdateSpreadsheetRequest) error {
+	return s.do(ctx, OpBatchUpdate, false, func() error {
+		_, err := s.srv.Spreadsheets.BatchUpdate(s.spreadsheetID, req).Context(ctx).Do()
+		return err
+	})
+}
+
+// do runs call until it succeeds, fails permanently or runs out of attempts,
+// then records the whole operation including the time spent in backoff.
+// Idempotent operations are retried on server errors too; the others only
+// when the request was rejected before being applied.
+func (s *Sheets) do(ctx context.Context, op string, idempotent bool, call func() error) error {
+	ctx, span := tracing.Start(ctx, "sheets."+op, tracing.KindClient)
+	start := time.Now()
+	backoff := s.Backoff
+
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		err = call()
+		if err == nil || attempt >= s.MaxAttempts || !retryable(err, idempotent) {
+			break
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		if ctx.Err() != nil {
+			// The caller gave up; the last Sheets error is not why the call failed
+			err = ctx.Err()
+			break
+		}
+		backoff *= 2
+	}
+
+	record(ctx, op, time.Since(start), attempt-1, err)
+	span.End(err)
+	return err
+}
+
+func retryable(err error, idempotent bool) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	return idempotent && apiErr.Code >= http.StatusInternalServerError
+}
+
+func record(ctx context.Context, op string, duration time.Duration, retries int, err error) {
+	method, ok := grpc.Method(ctx)
+	if !ok {
+		method = "none"
+	}
+
+	outcome := "success"
+	httpStatus := http.StatusOK
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		outcome = "cancelled"
+		httpStatus = 0
+	case err != nil:
+		outcome = "error"
+		httpStatus = 0
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) {
+			httpStatus = apiErr.Code
+		}
+	}
+
+	fields := map[string]interface{}{
+		"error":       err != nil,
+		"retries":     retries,
+		"http_status": httpStatus,
+		"request_id":  requestid.FromContext(ctx),
+	}
+	for k, v := range tracing.ExemplarFields(ctx) {
+		fields[k] = v
+	}
+
+	metrics.WriteBackend(
+		duration,
+		map[string]string{
+			"backend":   "sheets",
+			"operation": op,
+			"endpoint":  method,
+			"outcome":   outcome,
+		},
+		fields,
+	)
+}
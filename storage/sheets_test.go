@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/influxtest"
+	"github.com/Ling-Qingran/gRPC-Observability/metrics"
+	"github.com/Ling-Qingran/gRPC-Observability/requestid"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+	"google.golang.org/grpc"
+)
+
+// fakeSheets answers Sheets API calls with the given statuses in turn, then
+// with 200, and counts the calls.
+type fakeSheets struct {
+	statuses []int
+	calls    atomic.Int32
+}
+
+func (f *fakeSheets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := int(f.calls.Add(1))
+	w.Header().Set("Content-Type", "application/json")
+	if n <= len(f.statuses) {
+		w.WriteHeader(f.statuses[n-1])
+		w.Write([]byte(`{"error": {"code": 0, "message": "failed"}}`))
+		return
+	}
+	w.Write([]byte(`{"range": "Sheet1!A1", "values": [["John Doe"]]}`))
+}
+
+// startSheets serves fake over HTTP and points the metrics sink at a fake
+// InfluxDB.
+func startSheets(t *testing.T, fake *fakeSheets) (*Sheets, *influxtest.Server) {
+	t.Helper()
+	influx := influxtest.NewServer("test-org", "test-bucket", "test-token")
+	t.Cleanup(influx.Close)
+	metrics.SetSink(metrics.Sink{URL: influx.URL, Token: influx.Token, Org: influx.Org, Bucket: influx.Bucket})
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	svc, err := sheets.NewService(context.Background(), option.WithEndpoint(srv.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSheets(svc, "sheet-id")
+	s.Backoff = time.Millisecond
+	return s, influx
+}
+
+// methodStream makes grpc.Method report the method a call was made for.
+type methodStream struct {
+	grpc.ServerTransportStream
+	method string
+}
+
+func (s methodStream) Method() string { return s.method }
+
+func TestRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		statuses  []int
+		write     bool
+		wantCalls int32
+		wantErr   bool
+	}{
+		{"rate limited read", []int{429, 429}, false, 3, false},
+		{"server error read", []int{503}, false, 2, false},
+		{"out of attempts", []int{500, 500, 500, 500}, false, 3, true},
+		{"client error", []int{400}, false, 1, true},
+		{"rate limited append", []int{429}, true, 2, false},
+		{"server error append", []int{503}, true, 1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := &fakeSheets{statuses: tc.statuses}
+			s, _ := startSheets(t, fake)
+
+			var err error
+			if tc.write {
+				err = s.Append(context.Background(), "Sheet1!A1", &sheets.ValueRange{Values: [][]interface{}{{"John Doe"}}})
+			} else {
+				_, err = s.Get(context.Background(), "Sheet1!A1")
+			}
+			if (err != nil) != tc.wantErr {
+				t.Errorf("error = %v, want error %v", err, tc.wantErr)
+			}
+			if got := fake.calls.Load(); got != tc.wantCalls {
+				t.Errorf("Sheets saw %d calls, want %d", got, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestStopsOnCancel(t *testing.T) {
+	fake := &fakeSheets{statuses: []int{503, 503}}
+	s, influx := startSheets(t, fake)
+	s.Backoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := s.Get(ctx, "Sheet1!A1")
+	if err != context.Canceled {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("returned after %v, want soon after cancel", d)
+	}
+	if got := fake.calls.Load(); got != 1 {
+		t.Errorf("Sheets saw %d calls, want 1", got)
+	}
+
+	points := influx.WaitFor(metrics.BackendMeasurement, 1, 5*time.Second)
+	if len(points) != 1 || points[0].Tags["outcome"] != "cancelled" || points[0].Fields["http_status"] != int64(0) {
+		t.Errorf("points = %+v, want one cancelled call without an HTTP status", points)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	fake := &fakeSheets{statuses: []int{429}}
+	s, influx := startSheets(t, fake)
+
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), methodStream{method: "/UserService/GetUser"})
+	ctx = requestid.NewContext(ctx, "req-1")
+	if _, err := s.Get(ctx, "Sheet1!A1"); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	fake.calls.Store(0)
+	fake.statuses = []int{404}
+	if err := s.Update(context.Background(), "Sheet1!A1", &sheets.ValueRange{}); err == nil {
+		t.Fatal("Update succeeded, want 404")
+	}
+
+	points := influx.WaitFor(metrics.BackendMeasurement, 2, 5*time.Second)
+	if len(points) != 2 {
+		t.Fatalf("got %d %s points, want 2", len(points), metrics.BackendMeasurement)
+	}
+	for _, tc := range []struct {
+		tags   map[string]string
+		fields map[string]interface{}
+	}{
+		{
+			map[string]string{"backend": "sheets", "operation": OpValuesGet, "endpoint": "/UserService/GetUser", "outcome": "success"},
+			map[string]interface{}{"error": false, "retries": int64(1), "http_status": int64(200), "request_id": "req-1"},
+		},
+		{
+			map[string]string{"backend": "sheets", "operation": OpValuesUpdate, "endpoint": "none", "outcome": "error"},
+			map[string]interface{}{"error": true, "retries": int64(0), "http_status": int64(404)},
+		},
+	} {
+		var p *influxtest.Point
+		for i := range points {
+			if points[i].Tags["operation"] == tc.tags["operation"] {
+				p = &points[i]
+			}
+		}
+		if p == nil {
+			t.Errorf("no point for %s", tc.tags["operation"])
+			continue
+		}
+		for k, want := range tc.tags {
+			if got := p.Tags[k]; got != want {
+				t.Errorf("%s: tag %s = %q, want %q", tc.tags["operation"], k, got, want)
+			}
+		}
+		for k, want := range tc.fields {
+			if got := p.Fields[k]; got != want {
+				t.Errorf("%s: field %s = %v (%T), want %v (%T)", tc.tags["operation"], k, got, got, want, want)
+			}
+		}
+		if d, ok := p.Fields["duration"].(float64); !ok || d < 0 {
+			t.Errorf("%s: field duration = %v, want a non-negative float", tc.tags["operation"], p.Fields["duration"])
+		}
+	}
+}
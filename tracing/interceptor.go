@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryServerInterceptor starts a server span for each call, continuing the
+// trace named in the incoming traceparent metadata if there is one.
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, span := startServerSpan(ctx, info.FullMethod)
+	resp, err := handler(ctx, req)
+	span.End(err)
+	return resp, err
+}
+
+// StreamServerInterceptor is the streaming counterpart of
+// UnaryServerInterceptor. The span covers the whole stream.
+func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, span := startServerSpan(ss.Context(), info.FullMethod)
+	err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	span.End(err)
+	return err
+}
+
+func startServerSpan(ctx context.Context, method string) (context.Context, *Span) {
+	var parent SpanContext
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(TraceparentKey); len(v) > 0 {
+			if sc, ok := ParseTraceparent(v[0]); ok {
+				parent = sc
+				parent.TraceState = joinTracestate(md.Get(TracestateKey))
+			}
+		}
+	}
+	span := startSpan(parent, method, KindServer)
+	return ContextWithSpan(ctx, span), span
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+
+// UnaryClientInterceptor starts a client span for each outgoing call and
+// propagates it in the traceparent and tracestate metadata.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, span := startClientSpan(ctx, method, cc)
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	span.End(err)
+	return err
+}
+
+// StreamClientInterceptor is the streaming counterpart of
+// UnaryClientInterceptor. The span ends when the stream finishes.
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, span := startClientSpan(ctx, method, cc)
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		span.End(err)
+		return nil, err
+	}
+	return &clientStream{ClientStream: cs, desc: desc, span: span}, nil
+}
+
+func startClientSpan(ctx context.Context, method string, cc *grpc.ClientConn) (context.Context, *Span) {
+	ctx, span := Start(ctx, method, KindClient)
+	span.SetAttribute("target", cc.Target())
+
+	pairs := []string{TraceparentKey, span.Traceparent()}
+	if span.TraceState != "" {
+		pairs = append(pairs, TracestateKey, span.TraceState)
+	}
+	return metadata.AppendToOutgoingContext(ctx, pairs...), span
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	desc *grpc.StreamDesc
+	span *Span
+	once sync.Once
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	case !s.desc.ServerStreams:
+		s.finish(nil)
+	}
+	return err
+}
+
+func (s *clientStream) finish(err error) {
+	s.once.Do(func() { s.span.End(err) })
+}
+
+// joinTracestate combines tracestate values split across metadata entries,
+// as the specification allows for HTTP headers.
+func joinTracestate(values []string) string {
+	var members []string
+	for _, v := range values {
+		for _, m := range strings.Split(v, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				members = append(members, m)
+			}
+		}
+	}
+	return strings.Join(members, ",")
+}
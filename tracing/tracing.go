@@ -0,0 +1,247 @@
+// Package tracing implements W3C Trace Context propagation and spans for gRPC
+// calls. Incoming "traceparent" and "tracestate" metadata continue the
+// caller's trace, outgoing calls carry the current span on, and finished
+// spans of sampled traces are queued for the gRPCSpans measurement, which the
+// metrics package writes in batches in the background.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Ling-Qingran/gRPC-Observability/metrics"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// Metadata keys defined by the W3C Trace Context specification.
+const (
+	TraceparentKey = "traceparent"
+	TracestateKey  = "tracestate"
+)
+
+// Span kinds used for the "kind" tag.
+const (
+	KindServer   = "server"
+	KindClient   = "client"
+	KindInternal = "internal"
+)
+
+// SpanContext is the part of a span that crosses process boundaries.
+type SpanContext struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid reports whether sc carries non-zero trace and span IDs.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceIDString returns the trace ID as 32 lowercase hex digits.
+func (sc SpanContext) TraceIDString() string { return hex.EncodeToString(sc.TraceID[:]) }
+
+// SpanIDString returns the span ID as 16 lowercase hex digits.
+func (sc SpanContext) SpanIDString() string { return hex.EncodeToString(sc.SpanID[:]) }
+
+// Traceparent formats sc as a version 00 traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceIDString() + "-" + sc.SpanIDString() + "-" + flags
+}
+
+// ParseTraceparent parses a traceparent header. Unknown future versions are
+// accepted as long as the fields this version defines are well formed. As
+// the specification requires, hex digits must be lowercase.
+func ParseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	for _, p := range parts[:4] {
+		if !isLowerHex(p) {
+			return sc, false
+		}
+	}
+	hex.Decode(sc.TraceID[:], []byte(parts[1]))
+	hex.Decode(sc.SpanID[:], []byte(parts[2]))
+	var flags [1]byte
+	hex.Decode(flags[:], []byte(parts[3]))
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9') && !('a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// sampleRate holds the fraction of new traces that are sampled, as float64
+// bits.
+var sampleRate atomic.Uint64
+
+func init() {
+	sampleRate.Store(math.Float64bits(1))
+}
+
+// SetSampleRate sets the fraction of new traces that are sampled, from 0 to
+// 1. It applies to traces started here; a continued trace keeps the
+// caller's decision. The default is 1.
+func SetSampleRate(rate float64) {
+	sampleRate.Store(math.Float64bits(math.Max(0, math.Min(1, rate))))
+}
+
+// sampled decides whether a new trace is sampled from its ID, so the same
+// trace gets the same decision at any rate wherever it is made.
+func sampled(traceID [16]byte) bool {
+	rate := math.Float64frombits(sampleRate.Load())
+	if rate >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(traceID[8:])) < rate*math.MaxUint64
+}
+
+// Span is one timed operation within a trace.
+type Span struct {
+	SpanContext
+	Parent [8]byte
+	Name   string
+	Kind   string
+	Start  time.Time
+
+	mu    sync.Mutex
+	attrs map[string]string
+	ended bool
+}
+
+// SetAttribute attaches a low-cardinality attribute, exported as a tag.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = map[string]string{}
+	}
+	s.attrs[key] = value
+}
+
+// End finishes the span with the outcome of err and exports it if sampled.
+// Calls after the first are ignored.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	attrs := s.attrs
+	s.mu.Unlock()
+
+	if s.Sampled {
+		export(s, time.Since(s.Start), err, attrs)
+	}
+}
+
+type spanKey struct{}
+
+// FromContext returns the current span, or nil if there is none.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithSpan returns a copy of ctx carrying span as the current span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// Start begins a span as a child of the current span in ctx, or as the root
+// of a new trace if there is none.
+func Start(ctx context.Context, name, kind string) (context.Context, *Span) {
+	var parent SpanContext
+	if p := FromContext(ctx); p != nil {
+		parent = p.SpanContext
+	}
+	span := startSpan(parent, name, kind)
+	return ContextWithSpan(ctx, span), span
+}
+
+// startSpan begins a span under parent. A zero parent starts a new trace,
+// sampled at the configured rate; otherwise the trace ID, sampling decision
+// and trace state carry over.
+func startSpan(parent SpanContext, name, kind string) *Span {
+	span := &Span{Name: name, Kind: kind, Start: time.Now()}
+	if parent.IsValid() {
+		span.TraceID = parent.TraceID
+		span.Parent = parent.SpanID
+		span.Sampled = parent.Sampled
+		span.TraceState = parent.TraceState
+	} else {
+		rand.Read(span.TraceID[:])
+		span.Sampled = sampled(span.TraceID)
+	}
+	rand.Read(span.SpanID[:])
+	return span
+}
+
+// ExemplarFields returns the fields linking a metric point to the current
+// trace, or nil when ctx carries no span or the trace is not sampled, as its
+// spans are never exported.
+func ExemplarFields(ctx context.Context) map[string]interface{} {
+	span := FromContext(ctx)
+	if span == nil || !span.Sampled {
+		return nil
+	}
+	return map[string]interface{}{
+		"trace_id": span.TraceIDString(),
+		"span_id":  span.SpanIDString(),
+	}
+}
+
+func export(s *Span, duration time.Duration, err error, attrs map[string]string) {
+	tags := map[string]string{
+		"name":        s.Name,
+		"kind":        s.Kind,
+		"status_code": grpcstatus.Code(err).String(),
+	}
+	for k, v := range attrs {
+		tags[k] = v
+	}
+
+	fields := map[string]interface{}{
+		"trace_id": s.TraceIDString(),
+		"span_id":  s.SpanIDString(),
+		"error":    err != nil,
+	}
+	if s.Parent != [8]byte{} {
+		fields["parent_span_id"] = hex.EncodeToString(s.Parent[:])
+	}
+	if err != nil {
+		fields["error_message"] = err.Error()
+	}
+	metrics.WriteSpan(s.Start, duration, tags, fields)
+}
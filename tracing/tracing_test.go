@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	for _, tc := range []struct {
+		name    string
+		header  string
+		ok      bool
+		sampled bool
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", true, true},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", true, false},
+		{"other flags ignored", "00-" + traceID + "-" + spanID + "-09", true, true},
+		{"unknown flags only", "00-" + traceID + "-" + spanID + "-08", true, false},
+		{"surrounding space", " 00-" + traceID + "-" + spanID + "-01 ", true, true},
+		{"future version", "cc-" + traceID + "-" + spanID + "-01-extra", true, true},
+		{"version ff", "ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"extra field in version 00", "00-" + traceID + "-" + spanID + "-01-extra", false, false},
+		{"bad version", "0x-" + traceID + "-" + spanID + "-01", false, false},
+		{"uppercase version", "0A-" + traceID + "-" + spanID + "-01", false, false},
+		{"uppercase trace ID", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", false, false},
+		{"uppercase span ID", "00-" + traceID + "-00F067AA0BA902B7-01", false, false},
+		{"uppercase flags", "00-" + traceID + "-" + spanID + "-0A", false, false},
+		{"zero trace ID", "00-00000000000000000000000000000000-" + spanID + "-01", false, false},
+		{"zero span ID", "00-" + traceID + "-0000000000000000-01", false, false},
+		{"short trace ID", "00-" + traceID[1:] + "-" + spanID + "-01", false, false},
+		{"short flags", "00-" + traceID + "-" + spanID + "-1", false, false},
+		{"missing field", "00-" + traceID + "-" + spanID, false, false},
+		{"not hex", "00-" + traceID[:31] + "g-" + spanID + "-01", false, false},
+		{"empty", "", false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tc.header)
+			if ok != tc.ok {
+				t.Fatalf("ParseTraceparent(%q) ok = %v, want %v", tc.header, ok, tc.ok)
+			}
+			if !ok {
+				return
+			}
+			if sc.Sampled != tc.sampled {
+				t.Errorf("Sampled = %v, want %v", sc.Sampled, tc.sampled)
+			}
+			if sc.TraceIDString() != traceID || sc.SpanIDString() != spanID {
+				t.Errorf("IDs = %s/%s, want %s/%s", sc.TraceIDString(), sc.SpanIDString(), traceID, spanID)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	for _, sampled := range []bool{true, false} {
+		span := startSpan(SpanContext{}, "test", KindInternal)
+		span.Sampled = sampled
+		sc, ok := ParseTraceparent(span.Traceparent())
+		if !ok || sc.TraceID != span.TraceID || sc.SpanID != span.SpanID || sc.Sampled != sampled {
+			t.Errorf("ParseTraceparent(%q) = %+v, %v", span.Traceparent(), sc, ok)
+		}
+	}
+}
+
+func TestSampleRate(t *testing.T) {
+	t.Cleanup(func() { SetSampleRate(1) })
+
+	count := func() int {
+		n := 0
+		for i := 0; i < 10000; i++ {
+			if startSpan(SpanContext{}, "test", KindInternal).Sampled {
+				n++
+			}
+		}
+		return n
+	}
+	SetSampleRate(1)
+	if n := count(); n != 10000 {
+		t.Errorf("rate 1 sampled %d of 10000", n)
+	}
+	SetSampleRate(0)
+	if n := count(); n != 0 {
+		t.Errorf("rate 0 sampled %d of 10000", n)
+	}
+	SetSampleRate(0.25)
+	if n := count(); n < 2200 || n > 2800 {
+		t.Errorf("rate 0.25 sampled %d of 10000", n)
+	}
+
+	// A continued trace keeps the caller's decision whatever the rate
+	SetSampleRate(0)
+	parent := SpanContext{TraceID: [16]byte{1}, SpanID: [8]byte{1}, Sampled: true}
+	if !startSpan(parent, "child", KindServer).Sampled {
+		t.Error("child of a sampled parent not sampled")
+	}
+}
+
+func TestExemplarFieldsOnlyForSampledTraces(t *testing.T) {
+	t.Cleanup(func() { SetSampleRate(1) })
+	if f := ExemplarFields(context.Background()); f != nil {
+		t.Errorf("ExemplarFields without a span = %v", f)
+	}
+
+	SetSampleRate(1)
+	ctx, _ := Start(context.Background(), "sampled", KindInternal)
+	if f := ExemplarFields(ctx); f["trace_id"] == nil {
+		t.Errorf("ExemplarFields of a sampled trace = %v, want trace_id", f)
+	}
+
+	SetSampleRate(0)
+	ctx, _ = Start(context.Background(), "unsampled", KindInternal)
+	if f := ExemplarFields(ctx); f != nil {
+		t.Errorf("ExemplarFields of an unsampled trace = %v, want nil", f)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+// version and commit identify the build. Release builds set them with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD)"
+//
+// Otherwise commit falls back to the VCS revision the go command embeds.
+var (
+	version = "dev"
+	commit  = ""
+)
+
+// startTime is when the process started, for uptime in CheckStatus.
+var startTime = time.Now()
+
+func init() {
+	if commit != "" {
+		return
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				commit = s.Value
+			}
+		}
+	}
+}